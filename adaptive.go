@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// adaptInterval is how often the concurrency controller re-evaluates the
+// number of directories read in parallel.
+const adaptInterval = 250 * time.Millisecond
+
+// baselineWindow is how many intervals the latency baseline looks back.
+// Latency that climbs gradually as workers are added still counts as
+// congestion for this long, while a minimum measured on cached metadata
+// long ago is eventually forgotten.
+const baselineWindow = 40
+
+// concurrencyController tunes the number of in-flight directory reads with
+// AIMD, the way TCP tunes its congestion window: while per-operation latency
+// stays close to the best seen and throughput keeps up, one more worker is
+// allowed each interval; once latency climbs well above that baseline the
+// storage is saturated (spinning disks seek, NFS servers queue) and the limit
+// is halved. An increase that made throughput drop is taken back.
+type concurrencyController struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	inFlight int
+	min, max int
+
+	ops       atomic.Int64 // stat/readdir operations in the current interval
+	busyNanos atomic.Int64 // time spent in them, summed over workers
+
+	// latencies holds the per-operation latency, in nanoseconds, of the
+	// last baselineWindow intervals; their minimum is the baseline.
+	latencies      []float64
+	next           int
+	lastThroughput float64 // operations per second in the previous interval
+	increased      bool    // whether the previous step added a worker
+	report         func(workers int, latency time.Duration, opsPerSec float64)
+}
+
+func newConcurrencyController(start int, opts walkOptions) *concurrencyController {
+	c := &concurrencyController{min: opts.MinWorkers, max: opts.MaxWorkers, report: opts.OnConcurrency}
+	if c.min <= 0 {
+		c.min = 1
+	}
+	if c.max < c.min {
+		c.max = c.min
+	}
+	c.limit = min(max(start, c.min), c.max)
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// acquire blocks until the worker may start another directory read.
+func (c *concurrencyController) acquire() {
+	c.mu.Lock()
+	for c.inFlight >= c.limit {
+		c.cond.Wait()
+	}
+	c.inFlight++
+	c.mu.Unlock()
+}
+
+// release ends a read started after acquire and records how many operations
+// it performed and how long they took.
+func (c *concurrencyController) release(ops int, elapsed time.Duration) {
+	c.ops.Add(int64(ops))
+	c.busyNanos.Add(int64(elapsed))
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+	c.cond.Signal()
+}
+
+// baseline records latency and returns the lowest latency of the last
+// baselineWindow intervals, including this one.
+func (c *concurrencyController) baseline(latency float64) float64 {
+	if len(c.latencies) < baselineWindow {
+		c.latencies = append(c.latencies, latency)
+	} else {
+		c.latencies[c.next] = latency
+		c.next = (c.next + 1) % baselineWindow
+	}
+	lowest := latency
+	for _, l := range c.latencies {
+		lowest = min(lowest, l)
+	}
+	return lowest
+}
+
+// adjust applies one AIMD step from the measurements of the last interval.
+func (c *concurrencyController) adjust(interval time.Duration) {
+	ops := c.ops.Swap(0)
+	busy := c.busyNanos.Swap(0)
+	if ops == 0 {
+		return
+	}
+	latency := float64(busy) / float64(ops)
+	throughput := float64(ops) / interval.Seconds()
+	baseline := c.baseline(latency)
+
+	c.mu.Lock()
+	old := c.limit
+	switch {
+	case latency > 2*baseline:
+		// Multiplicative decrease: the device is queueing our requests.
+		c.limit = max(c.limit/2, c.min)
+	case throughput < 0.95*c.lastThroughput:
+		// The last worker added made things worse rather than better, so
+		// take it back; otherwise hold.
+		if c.increased {
+			c.limit = max(c.limit-1, c.min)
+		}
+	default:
+		// Additive increase: more parallelism is still paying off.
+		c.limit = min(c.limit+1, c.max)
+	}
+	limit := c.limit
+	c.mu.Unlock()
+	if limit > old {
+		c.cond.Broadcast()
+	}
+	c.increased = limit > old
+	c.lastThroughput = throughput
+
+	if c.report != nil {
+		c.report(limit, time.Duration(latency), throughput)
+	}
+}
+
+// run re-evaluates the limit every adaptInterval until stop is closed.
+func (c *concurrencyController) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(adaptInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			c.adjust(now.Sub(last))
+			last = now
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// ageLimits are the upper bounds of the age buckets; files older than the
+// last one go into a final "older" bucket.
+var ageLimits = []time.Duration{24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour, 365 * 24 * time.Hour}
+
+var ageLabels = []string{"<1d", "<7d", "<30d", "<1y", "older"}
+
+const numAgeBuckets = 5
+
+// ageCount is the number and size of the files in one bucket.
+type ageCount struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+func (c *ageCount) add(other ageCount) {
+	c.Bytes += other.Bytes
+	c.Files += other.Files
+}
+
+// dirAges holds the age buckets of everything beneath one directory, by
+// modification and by access time. Cold files were neither modified nor
+// read within the cold threshold.
+type dirAges struct {
+	mtime [numAgeBuckets]ageCount
+	atime [numAgeBuckets]ageCount
+	cold  ageCount
+}
+
+// ageBucket returns the bucket for something last touched at t. Times in the
+// future, from clock skew or restored archives, count as brand new.
+func ageBucket(now, t time.Time) int {
+	age := now.Sub(t)
+	for i, limit := range ageLimits {
+		if age < limit {
+			return i
+		}
+	}
+	return len(ageLimits)
+}
+
+// ageCollector buckets files by age as a walkOptions.OnEntry hook on a
+// buildSizeTree walk. It relies on the tree's visit function running first,
+// so ParentRef is the tree index of each file's directory.
+type ageCollector struct {
+	now  time.Time
+	cold time.Duration
+	dirs []*dirAges // by tree index; nil for files and empty directories
+}
+
+func (c *ageCollector) at(i int) *dirAges {
+	for len(c.dirs) <= i {
+		c.dirs = append(c.dirs, nil)
+	}
+	if c.dirs[i] == nil {
+		c.dirs[i] = &dirAges{}
+	}
+	return c.dirs[i]
+}
+
+func (c *ageCollector) visit(e *walkEntry) {
+	if e.Mode.IsDir() {
+		return
+	}
+	d := c.at(e.ParentRef)
+	file := ageCount{e.Size, 1}
+	d.mtime[ageBucket(c.now, e.ModTime)].add(file)
+	d.atime[ageBucket(c.now, e.ATime)].add(file)
+	last := e.ModTime
+	if e.ATime.After(last) {
+		last = e.ATime
+	}
+	if c.now.Sub(last) >= c.cold {
+		d.cold.add(file)
+	}
+}
+
+// finish rolls the buckets up so every directory covers its whole subtree.
+// Like sizeTree.finish it relies on parents coming before their children.
+func (c *ageCollector) finish(t *sizeTree) {
+	for len(c.dirs) < len(t.nodes) {
+		c.dirs = append(c.dirs, nil)
+	}
+	for i := len(t.nodes) - 1; i > 0; i-- {
+		d := c.dirs[i]
+		if d == nil {
+			continue
+		}
+		p := c.at(int(t.nodes[i].parent))
+		for b := 0; b < numAgeBuckets; b++ {
+			p.mtime[b].add(d.mtime[b])
+			p.atime[b].add(d.atime[b])
+		}
+		p.cold.add(d.cold)
+	}
+}
+
+// ageBucketJSON is one bucket in the JSON report.
+type ageBucketJSON struct {
+	Age string `json:"age"`
+	ageCount
+}
+
+// dirAgeJSON is one directory in the JSON report.
+type dirAgeJSON struct {
+	Path  string          `json:"path"`
+	Bytes int64           `json:"bytes"`
+	Cold  ageCount        `json:"cold"`
+	MTime []ageBucketJSON `json:"mtime"`
+	ATime []ageBucketJSON `json:"atime"`
+}
+
+// parseReferenceTime accepts an RFC 3339 time or a plain date.
+func parseReferenceTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid reference time %q (want 2006-01-02 or RFC 3339)", s)
+	}
+	return t, nil
+}
+
+// runAge implements `dirsize age [flags] [DIR]`.
+func runAge(args []string) int {
+	flags := flag.NewFlagSet("age", flag.ExitOnError)
+	nowFlag := flags.String("now", "", "compute ages against this time instead of the current one (2006-01-02 or RFC 3339)")
+	cold := flags.Duration("cold", 365*24*time.Hour, "files neither modified nor read for this long count as cold")
+	by := flags.String("by", "mtime", "which time the bucket columns show: mtime or atime (meaningless on noatime mounts)")
+	depth := flags.Int("depth", 1, "list directories down to this depth below DIR")
+	sortBy := flags.String("sort", "cold", "order directories by cold, size or path")
+	minSize := flags.String("min-size", "0", "leave out directories smaller than this")
+	noatime := flags.Bool("noatime", false, "don't update access times of the directories read (where permitted)")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	if *by != "mtime" && *by != "atime" {
+		fmt.Fprintf(os.Stderr, "invalid -by %q: want mtime or atime\n", *by)
+		return 2
+	}
+	if *sortBy != "cold" && *sortBy != "size" && *sortBy != "path" {
+		fmt.Fprintf(os.Stderr, "invalid -sort %q: want cold, size or path\n", *sortBy)
+		return 2
+	}
+	limit, err := parseByteSize(*minSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	now := time.Now()
+	if *nowFlag != "" {
+		if now, err = parseReferenceTime(*nowFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	ages := &ageCollector{now: now, cold: *cold}
+	tree, err := buildSizeTree(root, walkOptions{StatTimes: true, NoATime: *noatime, OnEntry: ages.visit})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ages.finish(tree)
+
+	// Pick the directories to show: parents come before children, so depths
+	// can be filled in a single forward pass.
+	depths := make([]int32, len(tree.nodes))
+	var dirs []uint32
+	for i := range tree.nodes {
+		if i > 0 {
+			depths[i] = depths[tree.nodes[i].parent] + 1
+		}
+		if tree.nodes[i].mode.IsDir() && int(depths[i]) <= *depth && tree.total(uint32(i)) >= limit {
+			dirs = append(dirs, uint32(i))
+		}
+	}
+	paths := make(map[uint32]string, len(dirs))
+	for _, i := range dirs {
+		paths[i] = tree.path(i)
+	}
+	coldBytes := func(i uint32) int64 {
+		if d := ages.dirs[i]; d != nil {
+			return d.cold.Bytes
+		}
+		return 0
+	}
+	sort.SliceStable(dirs, func(a, b int) bool {
+		x, y := dirs[a], dirs[b]
+		switch *sortBy {
+		case "size":
+			if tree.total(x) != tree.total(y) {
+				return tree.total(x) > tree.total(y)
+			}
+		case "cold":
+			if coldBytes(x) != coldBytes(y) {
+				return coldBytes(x) > coldBytes(y)
+			}
+		}
+		return paths[x] < paths[y]
+	})
+
+	if *asJSON {
+		out := make([]dirAgeJSON, 0, len(dirs))
+		for _, i := range dirs {
+			d := ages.dirs[i]
+			if d == nil {
+				d = &dirAges{}
+			}
+			j := dirAgeJSON{Path: paths[i], Bytes: tree.total(i), Cold: d.cold}
+			for b := 0; b < numAgeBuckets; b++ {
+				j.MTime = append(j.MTime, ageBucketJSON{ageLabels[b], d.mtime[b]})
+				j.ATime = append(j.ATime, ageBucketJSON{ageLabels[b], d.atime[b]})
+			}
+			out = append(out, j)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false) // keep the "<1d" labels readable
+		if err := enc.Encode(out); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Printf("Ages by %s as of %s; cold = untouched for %v\n\n", *by, now.Format("2006-01-02 15:04"), *cold)
+	fmt.Printf("%10s %10s", "SIZE", "COLD")
+	for _, label := range ageLabels {
+		fmt.Printf(" %10s", label)
+	}
+	fmt.Println("  PATH")
+	for _, i := range dirs {
+		d := ages.dirs[i]
+		if d == nil {
+			d = &dirAges{}
+		}
+		buckets := d.mtime
+		if *by == "atime" {
+			buckets = d.atime
+		}
+		fmt.Printf("%10s %10s", humanReadableBytes(tree.total(i)), humanReadableBytes(d.cold.Bytes))
+		for _, c := range buckets {
+			fmt.Printf(" %10s", humanReadableBytes(c.Bytes))
+		}
+		fmt.Printf("  %s\n", paths[i])
+	}
+	return 0
+}
@@ -0,0 +1,19 @@
+//go:build darwin || freebsd || netbsd
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// accessTime returns the last access time from an Lstat result, or the
+// modification time if there is none.
+func accessTime(info fs.FileInfo) time.Time {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(int64(st.Atimespec.Sec), int64(st.Atimespec.Nsec))
+}
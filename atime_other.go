@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package main
+
+import (
+	"io/fs"
+	"time"
+)
+
+// accessTime has no access time to offer here, so files are taken to have
+// been last read when they were last written.
+func accessTime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
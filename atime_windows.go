@@ -0,0 +1,18 @@
+package main
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// accessTime returns the last access time from an Lstat result, or the
+// modification time if there is none. NTFS only updates it lazily, within
+// an hour or so by default.
+func accessTime(info fs.FileInfo) time.Time {
+	d, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(0, d.LastAccessTime.Nanoseconds())
+}
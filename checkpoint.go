@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// scanState is everything needed to continue an interrupted scan. The walk is
+// driven by an explicit frontier of directories that have not been read yet,
+// so after each directory is finished the state is consistent: Total covers
+// every file in the directories already read and Pending lists the rest.
+type scanState struct {
+	Root    string   `json:"root"`
+	Total   int64    `json:"total"`
+	Files   int64    `json:"files"`
+	Dirs    int64    `json:"dirs"`
+	Pending []string `json:"pending"`
+}
+
+// loadScanState reads a state file written by saveScanState.
+func loadScanState(stateFile string) (*scanState, error) {
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file '%s': %w", stateFile, err)
+	}
+	var state scanState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file '%s': %w", stateFile, err)
+	}
+	return &state, nil
+}
+
+// saveScanState writes the state to a temporary file and renames it into
+// place, so a crash while saving never leaves a truncated checkpoint behind.
+func saveScanState(stateFile string, state *scanState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file '%s': %w", tmp, err)
+	}
+	if err := os.Rename(tmp, stateFile); err != nil {
+		return fmt.Errorf("failed to replace state file '%s': %w", stateFile, err)
+	}
+	return nil
+}
+
+// newScanState starts a fresh scan of dirPath.
+func newScanState(dirPath string) (*scanState, error) {
+	// The state may be resumed from another working directory.
+	dirPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory '%s': %w", dirPath, err)
+	}
+	state := &scanState{Root: dirPath}
+	if info.IsDir() {
+		state.Pending = []string{dirPath}
+	} else {
+		// Like filepath.Walk, a plain file as the root is simply counted.
+		state.Total, state.Files = info.Size(), 1
+	}
+	return state, nil
+}
+
+// step reads one directory from the frontier, counting its files and
+// queueing its subdirectories. Errors are reported and the entry skipped,
+// matching calculateDirSize.
+func (s *scanState) step() {
+	dir := s.Pending[len(s.Pending)-1]
+	s.Pending = s.Pending[:len(s.Pending)-1]
+	s.Dirs++
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Printf("Error accessing %s: %v\n", dir, err)
+	}
+	// ReadDir returns entries sorted by name; push subdirectories in reverse
+	// so they are popped in the same order filepath.Walk would visit them.
+	for i := len(entries) - 1; i >= 0; i-- {
+		path := filepath.Join(dir, entries[i].Name())
+		if entries[i].IsDir() {
+			s.Pending = append(s.Pending, path)
+			continue
+		}
+		info, err := entries[i].Info()
+		if err != nil {
+			fmt.Printf("Error accessing %s: %v\n", path, err)
+			continue
+		}
+		s.Total += info.Size()
+		s.Files++
+	}
+}
+
+// calculateDirSizeResumable walks the tree like calculateDirSize, but saves
+// its progress to stateFile every interval and on SIGINT/SIGTERM. The state
+// file is removed once the scan completes.
+func calculateDirSizeResumable(state *scanState, stateFile string, interval time.Duration) (int64, error) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for len(state.Pending) > 0 {
+		select {
+		case <-ticker.C:
+			if err := saveScanState(stateFile, state); err != nil {
+				return 0, err
+			}
+		case sig := <-interrupt:
+			if err := saveScanState(stateFile, state); err != nil {
+				return 0, err
+			}
+			return 0, fmt.Errorf("scan interrupted by %v, resume with --resume %s", sig, stateFile)
+		default:
+		}
+		state.step()
+	}
+
+	if err := os.Remove(stateFile); err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to remove state file '%s': %w", stateFile, err)
+	}
+	return state.Total, nil
+}
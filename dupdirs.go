@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"sort"
+)
+
+// maxPairsPerSet bounds how many copies of one file are paired up when
+// looking for near-identical directories. Files copied everywhere (licenses,
+// empty __init__.py) would otherwise pair every directory with every other.
+const maxPairsPerSet = 16
+
+// subtreeHashes holds a Merkle-style hash for every node of a sizeTree. A
+// file's hash is its content hash; a directory's hash covers the sorted names,
+// types and hashes of its children, so two directories hash the same exactly
+// when their whole subtrees are identical. Nodes that cannot have a twin (a
+// file whose content exists nowhere else, and every directory above it) are
+// marked unique instead and never hashed.
+type subtreeHashes struct {
+	hash   [][sha256.Size]byte
+	unique []bool
+}
+
+// childIndex lists the children of every node of a tree, in compressed
+// sparse row form: the children of node i are kids[start[i]:start[i+1]].
+type childIndex struct {
+	start []uint32
+	kids  []uint32
+}
+
+func newChildIndex(t *sizeTree) childIndex {
+	n := len(t.nodes)
+	c := childIndex{start: make([]uint32, n+1), kids: make([]uint32, max(n-1, 0))}
+	for i := 1; i < n; i++ {
+		c.start[t.nodes[i].parent+1]++
+	}
+	for i := 1; i <= n; i++ {
+		c.start[i] += c.start[i-1]
+	}
+	next := append([]uint32(nil), c.start[:n]...)
+	for i := 1; i < n; i++ {
+		p := t.nodes[i].parent
+		c.kids[next[p]] = uint32(i)
+		next[p]++
+	}
+	return c
+}
+
+func (c childIndex) children(i uint32) []uint32 {
+	return c.kids[c.start[i]:c.start[i+1]]
+}
+
+// hashSubtrees computes subtreeHashes for t. File contents are only hashed
+// for files that share their size with another file, using the same staged
+// hashing as the dupes command.
+func hashSubtrees(t *sizeTree, kids childIndex, workers int) subtreeHashes {
+	n := len(t.nodes)
+	h := subtreeHashes{hash: make([][sha256.Size]byte, n), unique: make([]bool, n)}
+
+	bySize := make(map[int64][]dupeFile)
+	nodeOf := make(map[string]uint32)
+	for i := 0; i < n; i++ {
+		node := &t.nodes[i]
+		switch {
+		case node.mode.IsDir():
+		case node.mode.IsRegular() && node.size > 0:
+			path := t.path(uint32(i))
+			nodeOf[path] = uint32(i)
+			bySize[node.size] = append(bySize[node.size], dupeFile{Path: path, Size: node.size})
+			h.unique[i] = true // until a twin turns up below
+		case node.mode&fs.ModeSymlink != 0:
+			// Links are the same when they point at the same target.
+			target, err := os.Readlink(t.path(uint32(i)))
+			if err != nil {
+				h.unique[i] = true
+				continue
+			}
+			h.hash[i] = sha256.Sum256([]byte("symlink\x00" + target))
+		default:
+			// Empty files and devices are compared by type and size only.
+			var buf [12]byte
+			binary.LittleEndian.PutUint32(buf[:4], uint32(node.mode))
+			binary.LittleEndian.PutUint64(buf[4:], uint64(node.size))
+			h.hash[i] = sha256.Sum256(buf[:])
+		}
+	}
+	for _, group := range identicalGroups(bySize, workers, &hashCache{}) {
+		for _, f := range group.files {
+			i := nodeOf[f.Path]
+			hex.Decode(h.hash[i][:], []byte(group.hash))
+			h.unique[i] = false
+		}
+	}
+
+	// Children always have larger indices than their parents, so walking
+	// backwards finishes every subtree before its directory.
+	for i := n - 1; i >= 0; i-- {
+		if !t.nodes[i].mode.IsDir() {
+			continue
+		}
+		children := append([]uint32(nil), kids.children(uint32(i))...)
+		sort.Slice(children, func(a, b int) bool { return t.name(children[a]) < t.name(children[b]) })
+		d := sha256.New()
+		for _, c := range children {
+			if h.unique[c] {
+				h.unique[i] = true
+				break
+			}
+			d.Write([]byte(t.name(c)))
+			d.Write([]byte{0, byte(t.nodes[c].mode >> 24)})
+			d.Write(h.hash[c][:])
+		}
+		if !h.unique[i] {
+			d.Sum(h.hash[i][:0])
+		}
+	}
+	return h
+}
+
+// duplicateDirs is a set of directories with identical subtrees.
+type duplicateDirs struct {
+	Size        int64    `json:"size"` // of each copy
+	Reclaimable int64    `json:"reclaimable"`
+	Paths       []string `json:"paths"`
+}
+
+// similarDirs is a pair of directories whose subtrees are mostly the same.
+type similarDirs struct {
+	Similarity   float64   `json:"similarity"`
+	SharedBytes  int64     `json:"shared_bytes"`
+	CombinedSize int64     `json:"combined_size"`
+	Paths        [2]string `json:"paths"`
+}
+
+// identicalDirs groups directories by subtree hash. A group is left out when
+// its members' parents are themselves identical, so a copied project shows up
+// once at the top and not again for each of its subdirectories.
+func identicalDirs(t *sizeTree, h subtreeHashes, minSize int64) []duplicateDirs {
+	byHash := make(map[[sha256.Size]byte][]uint32)
+	for i := range t.nodes {
+		if t.nodes[i].mode.IsDir() && !h.unique[i] && t.nodes[i].size >= minSize {
+			byHash[h.hash[i]] = append(byHash[h.hash[i]], uint32(i))
+		}
+	}
+
+	var sets []duplicateDirs
+	for _, members := range byHash {
+		if len(members) < 2 {
+			continue
+		}
+		// Nested means every member sits in a different parent and those
+		// parents are identical to each other.
+		nested := true
+		parents := make(map[uint32]bool)
+		parentHash := h.hash[t.nodes[members[0]].parent]
+		for _, m := range members {
+			p := t.nodes[m].parent
+			if m == 0 || parents[p] || h.unique[p] || h.hash[p] != parentHash {
+				nested = false
+				break
+			}
+			parents[p] = true
+		}
+		if nested {
+			continue
+		}
+		size := t.nodes[members[0]].size
+		set := duplicateDirs{Size: size, Reclaimable: size * int64(len(members)-1)}
+		for _, m := range members {
+			set.Paths = append(set.Paths, t.path(m))
+		}
+		sort.Strings(set.Paths)
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i].Reclaimable != sets[j].Reclaimable {
+			return sets[i].Reclaimable > sets[j].Reclaimable
+		}
+		return sets[i].Paths[0] < sets[j].Paths[0]
+	})
+	return sets
+}
+
+// nearIdenticalDirs finds pairs of directories that are not identical but
+// share at least similarity of their bytes as files with the same relative
+// path and content. Every pair of identical files with the same name credits
+// its size to each pair of ancestor directories it is found under at the
+// same relative path, climbing for as long as the directory names match.
+func nearIdenticalDirs(t *sizeTree, h subtreeHashes, minSize int64, similarity float64) []similarDirs {
+	type dirPair struct{ a, b uint32 }
+	shared := make(map[dirPair]int64)
+
+	byHash := make(map[[sha256.Size]byte][]uint32)
+	for i := range t.nodes {
+		if t.nodes[i].mode.IsRegular() && !h.unique[i] && t.nodes[i].size > 0 {
+			byHash[h.hash[i]] = append(byHash[h.hash[i]], uint32(i))
+		}
+	}
+	for _, files := range byHash {
+		if len(files) > maxPairsPerSet {
+			files = files[:maxPairsPerSet]
+		}
+		for x := 0; x < len(files); x++ {
+			for y := x + 1; y < len(files); y++ {
+				f1, f2 := files[x], files[y]
+				if t.name(f1) != t.name(f2) {
+					continue
+				}
+				size := t.nodes[f1].size
+				for a, b := t.nodes[f1].parent, t.nodes[f2].parent; a != b; a, b = t.nodes[a].parent, t.nodes[b].parent {
+					if a > b {
+						a, b = b, a
+					}
+					shared[dirPair{a, b}] += size
+					if a == 0 || t.name(a) != t.name(b) {
+						break
+					}
+				}
+			}
+		}
+	}
+
+	identical := func(p dirPair) bool {
+		return !h.unique[p.a] && !h.unique[p.b] && h.hash[p.a] == h.hash[p.b]
+	}
+	similar := func(p dirPair) (float64, bool) {
+		if identical(p) {
+			return 1, true
+		}
+		larger := max(t.nodes[p.a].size, t.nodes[p.b].size)
+		if larger == 0 {
+			return 0, false
+		}
+		s := float64(shared[p]) / float64(larger)
+		return s, s >= similarity
+	}
+
+	var pairs []similarDirs
+	for p, bytes := range shared {
+		s, ok := similar(p)
+		if !ok || identical(p) || min(t.nodes[p.a].size, t.nodes[p.b].size) < minSize {
+			continue
+		}
+		// Skip pairs whose parents are a reported pair themselves.
+		pa, pb := t.nodes[p.a].parent, t.nodes[p.b].parent
+		if pa > pb {
+			pa, pb = pb, pa
+		}
+		if p.a != 0 && p.b != 0 && pa != pb {
+			if _, ok := similar(dirPair{pa, pb}); ok {
+				continue
+			}
+		}
+		pairs = append(pairs, similarDirs{
+			Similarity:   s,
+			SharedBytes:  bytes,
+			CombinedSize: t.nodes[p.a].size + t.nodes[p.b].size,
+			Paths:        [2]string{t.path(p.a), t.path(p.b)},
+		})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].CombinedSize != pairs[j].CombinedSize {
+			return pairs[i].CombinedSize > pairs[j].CombinedSize
+		}
+		return pairs[i].Paths[0] < pairs[j].Paths[0]
+	})
+	return pairs
+}
+
+// runDupDirs implements `dirsize dupdirs [flags] [DIR]`.
+func runDupDirs(args []string) int {
+	flags := flag.NewFlagSet("dupdirs", flag.ExitOnError)
+	minSize := flags.String("min-size", "1", "ignore directories smaller than this")
+	similarity := flags.Float64("similarity", 1, "also report directory pairs sharing at least this fraction of their bytes (e.g. 0.9)")
+	workers := flags.Int("workers", runtime.NumCPU(), "files hashed in parallel")
+	asJSON := flags.Bool("json", false, "print the results as JSON")
+	flags.Parse(args)
+
+	if *workers < 1 {
+		fmt.Fprintln(os.Stderr, "dirsize dupdirs: -workers must be at least 1")
+		return 2
+	}
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	limit, err := parseByteSize(*minSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	tree, err := buildSizeTree(root, walkOptions{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	hashes := hashSubtrees(tree, newChildIndex(tree), *workers)
+	identical := identicalDirs(tree, hashes, max(limit, 1))
+	var near []similarDirs
+	if *similarity < 1 {
+		near = nearIdenticalDirs(tree, hashes, max(limit, 1), *similarity)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(struct {
+			Identical []duplicateDirs `json:"identical"`
+			Similar   []similarDirs   `json:"similar,omitempty"`
+		}{identical, near})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	for _, set := range identical {
+		fmt.Printf("%d identical copies of %s (%s reclaimable)\n", len(set.Paths),
+			humanReadableBytes(set.Size), humanReadableBytes(set.Reclaimable))
+		for _, path := range set.Paths {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+	for _, pair := range near {
+		fmt.Printf("%.0f%% similar, %s combined (%s shared)\n", 100*pair.Similarity,
+			humanReadableBytes(pair.CombinedSize), humanReadableBytes(pair.SharedBytes))
+		fmt.Printf("  %s\n  %s\n", pair.Paths[0], pair.Paths[1])
+	}
+	fmt.Printf("\n%d sets of identical directories", len(identical))
+	if *similarity < 1 {
+		fmt.Printf(", %d similar pairs", len(near))
+	}
+	fmt.Println()
+	return 0
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// edgeBlockSize is how much of the start and of the end of a file goes into
+// the cheap first-pass hash. Files no larger than two blocks skip straight to
+// the full hash, which then costs the same.
+const edgeBlockSize = 4096
+
+// dupeFile is a candidate for duplicate detection.
+type dupeFile struct {
+	Path    string
+	Size    int64
+	ModTime int64 // UnixNano, used to validate cached hashes
+	Dev     uint64
+	Ino     uint64
+}
+
+// duplicateSet is a group of files with identical content.
+type duplicateSet struct {
+	Size        int64    `json:"size"`
+	SHA256      string   `json:"sha256"`
+	Reclaimable int64    `json:"reclaimable"`
+	Paths       []string `json:"paths"`
+	// HardLinks lists further names of the same inodes, which share storage
+	// already and are not counted as reclaimable.
+	HardLinks []string `json:"hard_links,omitempty"`
+}
+
+// hashCacheEntry is one line of the hash cache file.
+type hashCacheEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Kind    string `json:"kind"` // "edges" or "full"
+	Hash    string `json:"sha256"`
+}
+
+// hashCache remembers hashes between runs, so an interrupted or repeated run
+// only hashes what changed. The file is append-only JSON lines: every hash is
+// written as soon as it is computed, and when loading, later lines win.
+type hashCache struct {
+	mu      sync.Mutex
+	entries map[string]hashCacheEntry // keyed by kind + NUL + path
+	w       *bufio.Writer
+	f       *os.File
+}
+
+func openHashCache(path string) (*hashCache, error) {
+	c := &hashCache{entries: make(map[string]hashCacheEntry)}
+	if path == "" {
+		return c, nil
+	}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var e hashCacheEntry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil {
+				c.entries[e.Kind+"\x00"+e.Path] = e
+			}
+		}
+		f.Close()
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hash cache '%s': %w", path, err)
+	}
+	c.f, c.w = f, bufio.NewWriter(f)
+	return c, nil
+}
+
+// lookup returns a cached hash if the file has not changed since.
+func (c *hashCache) lookup(kind string, file dupeFile) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[kind+"\x00"+file.Path]
+	if !ok || e.Size != file.Size || e.ModTime != file.ModTime {
+		return "", false
+	}
+	return e.Hash, true
+}
+
+func (c *hashCache) store(kind string, file dupeFile, hash string) {
+	if c.w == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := hashCacheEntry{Path: file.Path, Size: file.Size, ModTime: file.ModTime, Kind: kind, Hash: hash}
+	c.entries[kind+"\x00"+file.Path] = e
+	line, _ := json.Marshal(e)
+	c.w.Write(append(line, '\n'))
+	// Flush per entry so work survives an interrupted run.
+	c.w.Flush()
+}
+
+func (c *hashCache) close() {
+	if c.f != nil {
+		c.w.Flush()
+		c.f.Close()
+	}
+}
+
+// hashFile computes the SHA-256 of a whole file, or with edgesOnly of just
+// its first and last edgeBlockSize bytes.
+func hashFile(file dupeFile, edgesOnly bool) (string, error) {
+	f, err := openNoATime(file.Path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if edgesOnly {
+		buf := make([]byte, edgeBlockSize)
+		n, err := io.ReadFull(f, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+		h.Write(buf[:n])
+		if file.Size > 2*edgeBlockSize {
+			n, err = f.ReadAt(buf, file.Size-edgeBlockSize)
+			if err != nil && err != io.EOF {
+				return "", err
+			}
+			h.Write(buf[:n])
+		}
+	} else if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashJob and hashResult are the job and result types of the hashing pool.
+type hashJob struct {
+	file      dupeFile
+	edgesOnly bool
+}
+
+type hashResult struct {
+	file dupeFile
+	hash string
+	err  error
+}
+
+// hashWorker hashes files from jobs until the channel is closed, following
+// the worker pool pattern of chann,go.
+func hashWorker(jobs <-chan hashJob, results chan<- hashResult, cache *hashCache, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for job := range jobs {
+		kind := "full"
+		if job.edgesOnly {
+			kind = "edges"
+		}
+		hash, ok := cache.lookup(kind, job.file)
+		var err error
+		if !ok {
+			if hash, err = hashFile(job.file, job.edgesOnly); err == nil {
+				cache.store(kind, job.file, hash)
+			}
+		}
+		results <- hashResult{file: job.file, hash: hash, err: err}
+	}
+}
+
+// hashAll hashes files in parallel and returns the hash of each by path.
+// Files that could not be read are reported and left out.
+func hashAll(files []dupeFile, edgesOnly bool, workers int, cache *hashCache) map[string]string {
+	jobs := make(chan hashJob, len(files))
+	results := make(chan hashResult, len(files))
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go hashWorker(jobs, results, cache, &wg)
+	}
+	for _, f := range files {
+		jobs <- hashJob{file: f, edgesOnly: edgesOnly}
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+
+	hashes := make(map[string]string, len(files))
+	for r := range results {
+		if r.err != nil {
+			fmt.Printf("Error accessing %s: %v\n", r.file.Path, r.err)
+			continue
+		}
+		hashes[r.file.Path] = r.hash
+	}
+	return hashes
+}
+
+// dupeGroup is a set of files that are still candidates for being
+// identical, together with the hash of the last stage that grouped them.
+type dupeGroup struct {
+	hash  string
+	files []dupeFile
+}
+
+// regroup splits every group by the hash of its members and keeps only the
+// groups that still have more than one member.
+func regroup(groups []dupeGroup, hashes map[string]string) []dupeGroup {
+	var out []dupeGroup
+	for _, group := range groups {
+		byHash := make(map[string][]dupeFile)
+		var order []string
+		for _, f := range group.files {
+			h, ok := hashes[f.Path]
+			if !ok {
+				continue
+			}
+			if _, seen := byHash[h]; !seen {
+				order = append(order, h)
+			}
+			byHash[h] = append(byHash[h], f)
+		}
+		for _, h := range order {
+			if len(byHash[h]) > 1 {
+				out = append(out, dupeGroup{hash: h, files: byHash[h]})
+			}
+		}
+	}
+	return out
+}
+
+// identicalGroups takes files grouped by size and returns the groups of
+// files with identical content, each with its full SHA-256. Files of a size
+// nobody else has are never read; the rest are compared by the hash of their
+// first and last blocks before any file is read in full.
+func identicalGroups(bySize map[int64][]dupeFile, workers int, cache *hashCache) []dupeGroup {
+	var groups []dupeGroup
+	var small, large []dupeFile
+	for _, group := range bySize {
+		if len(group) < 2 {
+			continue
+		}
+		groups = append(groups, dupeGroup{files: group})
+		for _, f := range group {
+			if f.Size > 2*edgeBlockSize {
+				large = append(large, f)
+			} else {
+				small = append(small, f)
+			}
+		}
+	}
+
+	// Stage two only pays off for files larger than the edge blocks; small
+	// ones go straight to the full hash.
+	edgeHashes := hashAll(large, true, workers, cache)
+	for _, f := range small {
+		edgeHashes[f.Path] = "small"
+	}
+	groups = regroup(groups, edgeHashes)
+
+	var candidates []dupeFile
+	for _, group := range groups {
+		candidates = append(candidates, group.files...)
+	}
+	return regroup(groups, hashAll(candidates, false, workers, cache))
+}
+
+// findDuplicates walks root and returns the sets of files with identical
+// content, largest reclaimable space first. Candidates are narrowed down in
+// stages so that most files are never read: first by size, then by a hash of
+// their first and last blocks, and only then by a full SHA-256.
+func findDuplicates(root string, opts walkOptions, minSize int64, workers int, cache *hashCache) ([]duplicateSet, error) {
+	type inodeKey struct{ dev, ino uint64 }
+	bySize := make(map[int64][]dupeFile)
+	// Extra names of inodes already seen: hard links share their storage.
+	links := make(map[inodeKey][]string)
+	seen := make(map[inodeKey]bool)
+
+	opts.StatTimes = true
+	err := walkTree(root, opts, func(e *walkEntry) {
+		if !e.Mode.IsRegular() || e.Size < minSize {
+			return
+		}
+		key := inodeKey{e.Dev, e.Ino}
+		if e.Nlink > 1 {
+			if seen[key] {
+				links[key] = append(links[key], e.Path())
+				return
+			}
+			seen[key] = true
+		}
+		bySize[e.Size] = append(bySize[e.Size], dupeFile{
+			Path: e.Path(), Size: e.Size, ModTime: e.ModTime.UnixNano(), Dev: e.Dev, Ino: e.Ino,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := identicalGroups(bySize, workers, cache)
+	sets := make([]duplicateSet, 0, len(groups))
+	for _, group := range groups {
+		size := group.files[0].Size
+		set := duplicateSet{Size: size, SHA256: group.hash, Reclaimable: size * int64(len(group.files)-1)}
+		for _, f := range group.files {
+			set.Paths = append(set.Paths, f.Path)
+			set.HardLinks = append(set.HardLinks, links[inodeKey{f.Dev, f.Ino}]...)
+		}
+		sort.Strings(set.Paths)
+		sets = append(sets, set)
+	}
+
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i].Reclaimable != sets[j].Reclaimable {
+			return sets[i].Reclaimable > sets[j].Reclaimable
+		}
+		return sets[i].Paths[0] < sets[j].Paths[0]
+	})
+	return sets, nil
+}
+
+// runDupes implements `dirsize dupes [flags] [DIR]`.
+func runDupes(args []string) int {
+	flags := flag.NewFlagSet("dupes", flag.ExitOnError)
+	minSize := flags.String("min-size", "1", "ignore files smaller than this")
+	workers := flags.Int("workers", runtime.NumCPU(), "files hashed in parallel")
+	cachePath := flags.String("cache", "", "keep hashes in this file so later runs only hash what changed")
+	asJSON := flags.Bool("json", false, "print the duplicate sets as JSON")
+	link := flags.Bool("link", false, "replace verified copies on the same file system with hard links")
+	dryRun := flags.Bool("dry-run", false, "with -link, only show what would be linked")
+	journalPath := flags.String("journal", "", "with -link, append every replaced path to this undo journal (required)")
+	undo := flags.String("undo", "", "turn the hard links recorded in this journal back into separate copies")
+	flags.Parse(args)
+
+	if *workers < 1 {
+		fmt.Fprintln(os.Stderr, "dirsize dupes: -workers must be at least 1")
+		return 2
+	}
+	if *undo != "" {
+		restored, err := undoLinks(*undo)
+		fmt.Printf("Restored %d files\n", restored)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	if *link && !*dryRun && *journalPath == "" {
+		fmt.Fprintln(os.Stderr, "dirsize dupes: -link needs -journal so the change can be undone")
+		return 2
+	}
+
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	limit, err := parseByteSize(*minSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	cache, err := openHashCache(*cachePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer cache.close()
+
+	sets, err := findDuplicates(root, walkOptions{}, max(limit, 1), *workers, cache)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *link {
+		var journal *os.File
+		if !*dryRun {
+			f, err := os.OpenFile(*journalPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			defer f.Close()
+			journal = f
+		}
+		res, err := linkDuplicates(sets, *dryRun, journal)
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(res); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		} else {
+			verb := "Linked"
+			if *dryRun {
+				verb = "Would link"
+			}
+			for _, a := range res.Actions {
+				if a.Skipped != "" {
+					fmt.Printf("Skipping %s: %s\n", a.Path, a.Skipped)
+				} else {
+					fmt.Printf("%s %s -> %s\n", verb, a.Path, a.Target)
+				}
+			}
+			fmt.Printf("\n%s %d files, %s saved, %d skipped\n", verb, res.Linked, humanReadableBytes(res.Saved), res.Skipped)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(sets); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	var reclaimable int64
+	for _, set := range sets {
+		reclaimable += set.Reclaimable
+		fmt.Printf("%d copies of %s (%s reclaimable), sha256 %s\n", len(set.Paths),
+			humanReadableBytes(set.Size), humanReadableBytes(set.Reclaimable), set.SHA256[:16])
+		for _, path := range set.Paths {
+			fmt.Printf("  %s\n", path)
+		}
+		for _, path := range set.HardLinks {
+			fmt.Printf("  %s (hard link)\n", path)
+		}
+	}
+	fmt.Printf("\n%d duplicate sets, %s reclaimable\n", len(sets), humanReadableBytes(reclaimable))
+	return 0
+}
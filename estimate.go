@@ -0,0 +1,264 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// minEstimateProbes is the number of probes taken, and minEstimateLeaves
+// the number of distinct leaves they must have reached, before the
+// confidence interval is trusted enough to stop on precision alone.
+const (
+	minEstimateProbes = 30
+	minEstimateLeaves = 20
+)
+
+// z95 is the normal quantile for a two-sided 95% confidence interval.
+const z95 = 1.96
+
+// dirSample is what one read of a directory tells the estimator.
+type dirSample struct {
+	fileBytes int64 // sum of the sizes of the non-directory entries
+	files     int64
+	subdirs   []string
+}
+
+// sizeEstimate is the current answer of the estimator. The bounds are a 95%
+// confidence interval for the total number of bytes.
+type sizeEstimate struct {
+	Bytes     float64
+	Low, High float64
+	Files     float64
+	Probes    int
+	DirsRead  int
+	Elapsed   time.Duration
+	Exact     bool // every directory was read, so Bytes is the true total
+}
+
+// RelativeError is the half-width of the confidence interval relative to the
+// estimate.
+func (e sizeEstimate) RelativeError() float64 {
+	if e.Bytes == 0 {
+		return 0
+	}
+	return (e.High - e.Bytes) / e.Bytes
+}
+
+// sizeEstimator estimates the size of a tree with Knuth's random path
+// method: a probe walks from the root down to a leaf, picking one
+// subdirectory uniformly at random at every level, and multiplies what it
+// finds at each level by the product of the branching factors above it.
+// Every probe is an unbiased estimate of the total, so their mean converges
+// on the exact calculateDirSize result and their spread gives a confidence
+// interval.
+//
+// Directory listings are cached, so the upper levels are read once and later
+// probes mostly spend their time deeper down in the parts not seen yet.
+type sizeEstimator struct {
+	root  string
+	rng   *rand.Rand
+	cache map[string]*dirSample
+	// unread counts the directories seen in a listing but not read yet;
+	// once it drops to zero the cache holds the whole tree.
+	unread int
+	leaves map[string]bool // distinct leaves reached by probes
+
+	// Running mean and variance of the probes (Welford's algorithm).
+	n         int
+	mean, m2  float64
+	filesMean float64
+	start     time.Time
+}
+
+func newSizeEstimator(root string, seed uint64) *sizeEstimator {
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &sizeEstimator{
+		root:   root,
+		rng:    rand.New(rand.NewPCG(seed, seed)),
+		cache:  make(map[string]*dirSample),
+		unread: 1, // the root
+		leaves: make(map[string]bool),
+		start:  time.Now(),
+	}
+}
+
+// list reads a directory once and remembers what is in it. Errors are
+// reported and treated as an empty directory, as calculateDirSize does.
+func (s *sizeEstimator) list(dir string) *dirSample {
+	if sample, ok := s.cache[dir]; ok {
+		return sample
+	}
+	sample := &dirSample{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Printf("Error accessing %s: %v\n", dir, err)
+	}
+	for _, de := range entries {
+		path := filepath.Join(dir, de.Name())
+		if de.IsDir() {
+			sample.subdirs = append(sample.subdirs, path)
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			fmt.Printf("Error accessing %s: %v\n", path, err)
+			continue
+		}
+		sample.fileBytes += info.Size()
+		sample.files++
+	}
+	s.cache[dir] = sample
+	s.unread += len(sample.subdirs) - 1
+	return sample
+}
+
+// probe takes one random root-to-leaf path and returns its estimates of the
+// total bytes and files.
+func (s *sizeEstimator) probe() (bytes, files float64) {
+	weight := 1.0
+	for dir := s.root; ; {
+		sample := s.list(dir)
+		bytes += weight * float64(sample.fileBytes)
+		files += weight * float64(sample.files)
+		if len(sample.subdirs) == 0 {
+			s.leaves[dir] = true
+			return bytes, files
+		}
+		weight *= float64(len(sample.subdirs))
+		dir = sample.subdirs[s.rng.IntN(len(sample.subdirs))]
+	}
+}
+
+// step runs one probe and folds it into the running statistics.
+func (s *sizeEstimator) step() {
+	bytes, files := s.probe()
+	s.n++
+	delta := bytes - s.mean
+	s.mean += delta / float64(s.n)
+	s.m2 += delta * (bytes - s.mean)
+	s.filesMean += (files - s.filesMean) / float64(s.n)
+}
+
+// estimate returns the current estimate and its confidence interval.
+func (s *sizeEstimator) estimate() sizeEstimate {
+	e := sizeEstimate{
+		Bytes:    s.mean,
+		Low:      s.mean,
+		High:     s.mean,
+		Files:    s.filesMean,
+		Probes:   s.n,
+		DirsRead: len(s.cache),
+		Elapsed:  time.Since(s.start),
+	}
+	if s.n > 1 {
+		stdErr := math.Sqrt(s.m2/float64(s.n-1)) / math.Sqrt(float64(s.n))
+		e.Low = math.Max(0, s.mean-z95*stdErr)
+		e.High = s.mean + z95*stdErr
+	}
+	return e
+}
+
+// exact returns the true totals once every directory has been read.
+func (s *sizeEstimator) exact() sizeEstimate {
+	e := sizeEstimate{Probes: s.n, DirsRead: len(s.cache), Elapsed: time.Since(s.start), Exact: true}
+	for _, sample := range s.cache {
+		e.Bytes += float64(sample.fileBytes)
+		e.Files += float64(sample.files)
+	}
+	e.Low, e.High = e.Bytes, e.Bytes
+	return e
+}
+
+// converged reports whether the confidence interval of e can be trusted to
+// be within precision. An interval of zero width is never trusted: it only
+// means every probe found the same total, which on a skewed tree, such as
+// many empty branches and one large one, says nothing about the branches
+// not reached yet.
+func (s *sizeEstimator) converged(e sizeEstimate, precision float64) bool {
+	return s.n >= minEstimateProbes && len(s.leaves) >= minEstimateLeaves &&
+		s.m2 > 0 && e.RelativeError() <= precision
+}
+
+// estimateDirSize refines an estimate of the size of root until either the
+// relative error drops below precision or budget runs out. If the probes
+// end up reading every directory, the exact total is returned instead. onUpdate, if set,
+// is called with the intermediate estimate a few times a second.
+func estimateDirSize(root string, budget time.Duration, precision float64, seed uint64, onUpdate func(sizeEstimate)) (sizeEstimate, error) {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return sizeEstimate{}, fmt.Errorf("failed to walk directory '%s': %w", root, err)
+	}
+	if !info.IsDir() {
+		size := float64(info.Size())
+		return sizeEstimate{Bytes: size, Low: size, High: size, Files: 1}, nil
+	}
+
+	s := newSizeEstimator(root, seed)
+	deadline := s.start.Add(budget)
+	nextUpdate := s.start.Add(defaultProgressInterval)
+	for {
+		s.step()
+		if s.unread == 0 {
+			return s.exact(), nil
+		}
+		now := time.Now()
+		e := s.estimate()
+		if s.converged(e, precision) || now.After(deadline) {
+			return e, nil
+		}
+		if onUpdate != nil && now.After(nextUpdate) {
+			onUpdate(e)
+			nextUpdate = now.Add(defaultProgressInterval)
+		}
+	}
+}
+
+// runEstimate implements `dirsize estimate [flags] [DIR]`.
+func runEstimate(args []string) int {
+	flags := flag.NewFlagSet("estimate", flag.ExitOnError)
+	budget := flags.Duration("budget", 10*time.Second, "stop refining after this long")
+	precision := flags.Float64("precision", 0.05, "stop once the 95% confidence interval is within this fraction of the estimate")
+	seed := flags.Uint64("seed", 0, "random seed, for reproducible estimates (0 = random)")
+	flags.Parse(args)
+
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+
+	var onUpdate func(sizeEstimate)
+	if isTerminal(os.Stderr) {
+		onUpdate = func(e sizeEstimate) {
+			fmt.Fprintf(os.Stderr, "\r\033[K~%s ± %.1f%% after %d probes", humanReadableBytes(int64(e.Bytes)), 100*e.RelativeError(), e.Probes)
+		}
+	}
+	e, err := estimateDirSize(root, *budget, *precision, *seed, onUpdate)
+	if onUpdate != nil {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if e.Exact {
+		fmt.Printf("Size of %s: %s (%d bytes, exact: every directory was read)\n", root,
+			humanReadableBytes(int64(e.Bytes)), int64(e.Bytes))
+		fmt.Printf("%.0f files; %d probes over %d directories in %v\n",
+			e.Files, e.Probes, e.DirsRead, e.Elapsed.Round(time.Millisecond))
+		return 0
+	}
+	fmt.Printf("Estimated size of %s: %s ± %.1f%% (95%% CI %s to %s)\n", root,
+		humanReadableBytes(int64(e.Bytes)), 100*e.RelativeError(),
+		humanReadableBytes(int64(e.Low)), humanReadableBytes(int64(e.High)))
+	fmt.Printf("About %.0f files; %d probes over %d directories in %v\n",
+		e.Files, e.Probes, e.DirsRead, e.Elapsed.Round(time.Millisecond))
+	return 0
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSizedFile creates a sparse file of the given apparent size.
+func writeSizedFile(t *testing.T, path string, size int64) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkBrackets runs the estimator on root with several seeds and returns
+// how many of the intervals contained the true size.
+func checkBrackets(t *testing.T, root string, precision float64, seeds int) int {
+	t.Helper()
+	want, err := calculateDirSize(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits, exact := 0, 0
+	for seed := uint64(1); seed <= uint64(seeds); seed++ {
+		e, err := estimateDirSize(root, 5*time.Second, precision, seed, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Exact {
+			exact++
+		}
+		if e.Low <= float64(want) && float64(want) <= e.High {
+			hits++
+		} else {
+			t.Logf("seed %d: %.0f bytes, 95%% CI %.0f to %.0f, want %d (%d probes, exact %v)",
+				seed, e.Bytes, e.Low, e.High, want, e.Probes, e.Exact)
+		}
+	}
+	t.Logf("%d of %d intervals contained %d bytes; %d runs read the whole tree", hits, seeds, want, exact)
+	return hits
+}
+
+// TestEstimateSkewedTree covers a tree where almost every probe finds the
+// same total: a hundred empty branches and one with a large file. The
+// probes agree perfectly until one reaches the large file, so an interval
+// of zero width must not end the estimate.
+func TestEstimateSkewedTree(t *testing.T) {
+	root := t.TempDir()
+	writeSizedFile(t, filepath.Join(root, "small"), 6)
+	for i := 0; i < 100; i++ {
+		if err := os.Mkdir(filepath.Join(root, fmt.Sprintf("empty%03d", i)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSizedFile(t, filepath.Join(root, "big", "file"), 1000000)
+
+	if hits := checkBrackets(t, root, 0.05, 20); hits != 20 {
+		t.Errorf("%d of 20 intervals contained the true size, want all", hits)
+	}
+}
+
+// TestEstimateBalancedTree covers a tree where every directory looks the
+// same.
+func TestEstimateBalancedTree(t *testing.T) {
+	root := t.TempDir()
+	makeSyntheticTree(t, root, 4, 3, 10)
+	if hits := checkBrackets(t, root, 0.05, 10); hits != 10 {
+		t.Errorf("%d of 10 intervals contained the true size, want all", hits)
+	}
+}
+
+// TestEstimateRandomTree covers an irregular tree with uneven fan-out and
+// heavy-tailed file sizes, too large to be read completely. A 95% interval
+// may miss now and then, but not often.
+func TestEstimateRandomTree(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a tree of a few thousand directories")
+	}
+	root := t.TempDir()
+	rng := rand.New(rand.NewPCG(1, 2))
+	var build func(dir string, depth int)
+	build = func(dir string, depth int) {
+		for i := 1 + rng.IntN(4); i > 0; i-- {
+			size := int64(rng.ExpFloat64() * 4096)
+			if rng.IntN(50) == 0 {
+				size *= 100
+			}
+			writeSizedFile(t, filepath.Join(dir, fmt.Sprintf("f%d", i)), size)
+		}
+		if depth == 0 {
+			return
+		}
+		for i := 3 + rng.IntN(4); i > 0; i-- {
+			sub := filepath.Join(dir, fmt.Sprintf("d%d", i))
+			if err := os.Mkdir(sub, 0755); err != nil {
+				t.Fatal(err)
+			}
+			build(sub, depth-1)
+		}
+	}
+	build(root, 5)
+
+	if hits := checkBrackets(t, root, 0.1, 40); hits < 34 {
+		t.Errorf("%d of 40 intervals contained the true size, want at least 34", hits)
+	}
+}
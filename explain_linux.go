@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// mountInfo is one line of /proc/self/mountinfo.
+type mountInfo struct {
+	Point   string
+	Device  string // major:minor
+	FSType  string
+	Options string // super block options, comma-separated
+}
+
+// unescapeMountPath undoes the octal escapes (\040 for a space and so on)
+// the kernel uses for paths in mountinfo.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// readMounts lists the mounts visible to this process.
+func readMounts() ([]mountInfo, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	defer f.Close()
+
+	var mounts []mountInfo
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// id parent major:minor root point options [optional...] - type source super
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+		m := mountInfo{Point: unescapeMountPath(fields[4]), Device: fields[2]}
+		for i := 6; i < len(fields)-1; i++ {
+			if fields[i] == "-" {
+				m.FSType = fields[i+1]
+				if i+3 < len(fields) {
+					m.Options = fields[i+3]
+				}
+				break
+			}
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+// isWithinDir reports whether path is dir or lies beneath it.
+func isWithinDir(path, dir string) bool {
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
+
+// estimateMetadata estimates how much of the used blocks of m the file
+// system's own metadata takes, with a note on what the figure covers. ok is
+// false for file system types it knows nothing about.
+func estimateMetadata(m mountInfo, fs fsStats) (bytes int64, note string, ok bool) {
+	inUse := fs.Inodes - fs.InodesFree
+	switch m.FSType {
+	case "ext2", "ext3", "ext4":
+		// Unless mounted with minixdf, ext leaves its static metadata out
+		// of the block counts, and what grows with the files (extent
+		// blocks, xattr blocks) is part of their allocated blocks.
+		if !slices.Contains(strings.Split(m.Options, ","), "minixdf") {
+			return 0, "inode tables, bitmaps and journal are left out of df's figures", true
+		}
+		// Inode tables are allocated for every inode up front; 256 bytes
+		// is the mke2fs default.
+		return int64(fs.Inodes) * 256, fmt.Sprintf("inode tables for %d inodes of 256 bytes; bitmaps and journal not measured", fs.Inodes), true
+	case "xfs":
+		// XFS allocates inodes from the data blocks as files are created;
+		// 512 bytes is the mkfs.xfs default.
+		return int64(inUse) * 512, fmt.Sprintf("%d inodes in use of 512 bytes; free space and inode btrees not measured", inUse), true
+	case "tmpfs", "ramfs":
+		return 0, m.FSType + " keeps its metadata in kernel memory, outside its blocks", true
+	}
+	return 0, "", false
+}
+
+// explanation is the breakdown of the gap between what statfs says is used
+// and what a scan of the mount finds.
+type explanation struct {
+	Mount     mountInfo
+	FS        fsStats
+	Used      int64 // by statfs
+	Reserved  int64 // free but only usable by root
+	Scanned   int64 // allocated blocks of everything the walk reached
+	Apparent  int64 // sum of file sizes, for comparison
+	DirBlocks int64 // part of Scanned taken by directories themselves
+
+	Metadata      int64 // estimated, when MetadataKnown
+	MetadataKnown bool
+	MetadataNote  string
+
+	Deleted      []openFile // open but deleted, one per inode
+	DeletedBytes int64
+	ProcsDenied  int
+
+	Unreadable     []string
+	Submounts      []mountInfo
+	SubmountsBytes int64 // used on the submounts, by statfs
+}
+
+// explainMount scans the mount point holding path without crossing into
+// other file systems and collects everything needed to explain how far its
+// total is from the used blocks statfs reports.
+func explainMount(path string) (*explanation, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+	x := &explanation{}
+	for _, m := range mounts {
+		// Later lines win: a mount stacked on the same point hides the
+		// earlier one.
+		if isWithinDir(path, m.Point) && len(m.Point) >= len(x.Mount.Point) {
+			x.Mount = m
+		}
+	}
+	if x.Mount.Point == "" {
+		return nil, fmt.Errorf("no mount found for '%s'", path)
+	}
+	root := x.Mount.Point
+
+	if x.FS, err = statFS(root); err != nil {
+		return nil, err
+	}
+	x.Used = int64(x.FS.Blocks-x.FS.BlocksFree) * x.FS.BlockSize
+	x.Reserved = int64(x.FS.BlocksFree-x.FS.BlocksAvail) * x.FS.BlockSize
+	x.Metadata, x.MetadataNote, x.MetadataKnown = estimateMetadata(x.Mount, x.FS)
+
+	skip := make(map[string]bool)
+	devices := map[string]bool{x.Mount.Device: true}
+	for _, m := range mounts {
+		if m.Point != root && isWithinDir(m.Point, root) && !skip[m.Point] {
+			skip[m.Point] = true
+			x.Submounts = append(x.Submounts, m)
+			// Bind mounts repeat a file system that is already counted,
+			// possibly the one being explained, so each device is only
+			// counted once.
+			if devices[m.Device] {
+				continue
+			}
+			devices[m.Device] = true
+			if fs, err := statFS(m.Point); err == nil {
+				x.SubmountsBytes += int64(fs.Blocks-fs.BlocksFree) * fs.BlockSize
+			}
+		}
+	}
+
+	type inodeKey struct{ dev, ino uint64 }
+	seen := make(map[inodeKey]bool)
+	opts := walkOptions{
+		StatDirs: true,
+		SkipDir:  func(dir string) bool { return skip[dir] },
+		OnError: func(path string, err error) {
+			x.Unreadable = append(x.Unreadable, path)
+		},
+	}
+	err = walkTree(root, opts, func(e *walkEntry) {
+		if skip[e.Path()] && e.Mode.IsDir() {
+			return // the mount point directory belongs to the other file system
+		}
+		if e.Nlink > 1 && !e.Mode.IsDir() {
+			key := inodeKey{e.Dev, e.Ino}
+			if seen[key] {
+				return
+			}
+			seen[key] = true
+		}
+		allocated := e.Blocks * 512
+		x.Scanned += allocated
+		if e.Mode.IsDir() {
+			x.DirBlocks += allocated
+		} else {
+			x.Apparent += e.Size
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rootDev uint64
+	if info, err := os.Lstat(root); err == nil {
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			rootDev = uint64(st.Dev)
+		}
+	}
+	if x.Deleted, x.DeletedBytes, x.ProcsDenied, err = deletedOpenFiles(rootDev); err != nil {
+		return nil, err
+	}
+	sort.Strings(x.Unreadable)
+	return x, nil
+}
+
+// deletedOpenFiles returns the deleted files on device dev that processes
+// still hold open, one per inode and largest first, with the bytes they
+// keep allocated.
+func deletedOpenFiles(dev uint64) (deleted []openFile, bytes int64, denied int, err error) {
+	files, denied, err := listOpenFiles()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	counted := make(map[uint64]bool)
+	for _, f := range files {
+		if !f.Deleted || f.Dev != dev || counted[f.Ino] {
+			continue
+		}
+		counted[f.Ino] = true
+		deleted = append(deleted, f)
+		bytes += f.Blocks * 512
+	}
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].Blocks > deleted[j].Blocks })
+	return deleted, bytes, denied, nil
+}
+
+// print writes the explanation as a table that adds up to the used figure.
+func (x *explanation) print(top int) {
+	row := func(label string, bytes int64) {
+		fmt.Printf("  %-44s %12s\n", label, humanReadableBytes(bytes))
+	}
+	fmt.Printf("%s (%s, device %s)\n\n", x.Mount.Point, x.Mount.FSType, x.Mount.Device)
+	row("Used according to statfs (df)", x.Used)
+	row("Found by scanning (allocated blocks)", x.Scanned)
+	fmt.Printf("    of which directories themselves: %s; apparent file sizes: %s\n",
+		humanReadableBytes(x.DirBlocks), humanReadableBytes(x.Apparent))
+	gap := x.Used - x.Scanned
+	row("Gap", gap)
+
+	fmt.Printf("\nAttributed:\n")
+	row(fmt.Sprintf("Deleted files still held open (%d)", len(x.Deleted)), x.DeletedBytes)
+	if len(x.Unreadable) > 0 {
+		fmt.Printf("  %-44s %12s\n", fmt.Sprintf("Contents of unreadable directories (%d)", len(x.Unreadable)), "unknown")
+	}
+	if x.MetadataKnown {
+		row("File system metadata (estimated)", x.Metadata)
+		fmt.Printf("    %s\n", x.MetadataNote)
+	} else {
+		fmt.Printf("  %-44s %12s\n", "File system metadata", "not measured")
+		fmt.Printf("    no estimate for %s\n", x.Mount.FSType)
+	}
+	row("Unaccounted", gap-x.DeletedBytes-x.Metadata)
+	fmt.Printf("    metadata not estimated above, whatever the unreadable\n")
+	fmt.Printf("    directories hold, and files hidden underneath mount points\n")
+	if len(x.Submounts) > 0 {
+		fmt.Printf("\nNot part of either figure:\n")
+		row(fmt.Sprintf("Used on file systems mounted below (%d)", len(x.Submounts)), x.SubmountsBytes)
+	}
+
+	if len(x.Deleted) > 0 {
+		fmt.Printf("\nLargest deleted-but-open files:\n")
+		for i, f := range x.Deleted {
+			if i == top {
+				break
+			}
+			fmt.Printf("  %12s  PID %d (%s) fd %d  %s\n", humanReadableBytes(f.Blocks*512), f.PID, f.Command, f.FD, f.Path)
+		}
+	}
+	if x.ProcsDenied > 0 {
+		fmt.Printf("\n%d processes could not be inspected; run as root to see their open files.\n", x.ProcsDenied)
+	}
+	if len(x.Unreadable) > 0 {
+		fmt.Printf("\nUnreadable, so not counted:\n")
+		for i, path := range x.Unreadable {
+			if i == top {
+				fmt.Printf("  ... and %d more\n", len(x.Unreadable)-top)
+				break
+			}
+			fmt.Printf("  %s\n", path)
+		}
+	}
+	if x.Reserved > 0 {
+		fmt.Printf("\nReserved for root: %s. It is neither used nor available, which is why df's\n", humanReadableBytes(x.Reserved))
+		fmt.Printf("Use%% can reach 100%% while statfs still reports free blocks.\n")
+	}
+	if len(x.Submounts) > 0 {
+		fmt.Printf("\nFile systems mounted below %s:\n", x.Mount.Point)
+		for _, m := range x.Submounts {
+			fmt.Printf("  %s (%s)\n", m.Point, m.FSType)
+		}
+	}
+}
+
+// runExplain implements `dirsize explain [flags] MOUNTPOINT`.
+func runExplain(args []string) int {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	top := flags.Int("top", 10, "list at most this many deleted files and unreadable directories")
+	flags.Parse(args)
+
+	path := "/"
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+	x, err := explainMount(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	x.print(*top)
+	return 0
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestDeletedOpenFiles checks that a file unlinked while still open is
+// found and its blocks attributed to it.
+func TestDeletedOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "held")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, bytes, _, err := deletedOpenFiles(uint64(st.Dev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range deleted {
+		if d.Ino != uint64(st.Ino) {
+			continue
+		}
+		if d.PID != os.Getpid() || d.Path != path {
+			t.Errorf("found PID %d holding %s, want PID %d holding %s", d.PID, d.Path, os.Getpid(), path)
+		}
+		if d.Blocks*512 < 1<<20 || bytes < d.Blocks*512 {
+			t.Errorf("attributed %d bytes (%d in total), want at least 1 MiB", d.Blocks*512, bytes)
+		}
+		return
+	}
+	t.Fatalf("deleted file %s not found among %d deleted open files", path, len(deleted))
+}
+
+func TestEstimateMetadata(t *testing.T) {
+	fs := fsStats{Inodes: 1000, InodesFree: 400}
+	tests := []struct {
+		mount mountInfo
+		bytes int64
+		ok    bool
+	}{
+		{mountInfo{FSType: "ext4", Options: "rw,errors=remount-ro"}, 0, true},
+		{mountInfo{FSType: "ext4", Options: "rw,minixdf"}, 1000 * 256, true},
+		{mountInfo{FSType: "xfs", Options: "rw,attr2,inode64"}, 600 * 512, true},
+		{mountInfo{FSType: "tmpfs"}, 0, true},
+		{mountInfo{FSType: "btrfs"}, 0, false},
+	}
+	for _, tt := range tests {
+		bytes, note, ok := estimateMetadata(tt.mount, fs)
+		if bytes != tt.bytes || ok != tt.ok || ok && note == "" {
+			t.Errorf("%s (%s): %d bytes, %q, %v; want %d bytes, %v", tt.mount.FSType, tt.mount.Options, bytes, note, ok, tt.bytes, tt.ok)
+		}
+	}
+}
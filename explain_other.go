@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runExplain needs /proc to find deleted-but-open files and the mount table,
+// so it is only implemented on Linux.
+func runExplain(args []string) int {
+	fmt.Fprintln(os.Stderr, "dirsize explain is only supported on Linux")
+	return 1
+}
@@ -0,0 +1,264 @@
+//go:build linux && (amd64 || arm64)
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Flags and field masks for statx(2), which the syscall package does not
+// define.
+const (
+	atSymlinkNoFollow = 0x100
+	atStatxDontSync   = 0x4000 // don't force a round trip to NFS servers
+
+	statxType   = 0x1
+	statxMode   = 0x2
+	statxNlink  = 0x4
+	statxUid    = 0x8
+	statxGid    = 0x10
+	statxAtime  = 0x20
+	statxMtime  = 0x40
+	statxIno    = 0x100
+	statxSize   = 0x200
+	statxBlocks = 0x400
+
+	// statxMinimal is everything a size scan needs and nothing more, so file
+	// systems can skip filling in the rest.
+	statxMinimal = statxType | statxMode | statxNlink | statxIno | statxSize | statxBlocks
+)
+
+// statxTimestamp mirrors struct statx_timestamp.
+type statxTimestamp struct {
+	Sec  int64
+	Nsec uint32
+	_    int32
+}
+
+// statxBuf mirrors struct statx from <linux/stat.h>.
+type statxBuf struct {
+	Mask       uint32
+	Blksize    uint32
+	Attributes uint64
+	Nlink      uint32
+	Uid        uint32
+	Gid        uint32
+	Mode       uint16
+	_          uint16
+	Ino        uint64
+	Size       uint64
+	Blocks     uint64
+	AttrMask   uint64
+	Atime      statxTimestamp
+	Btime      statxTimestamp
+	Ctime      statxTimestamp
+	Mtime      statxTimestamp
+	RdevMajor  uint32
+	RdevMinor  uint32
+	DevMajor   uint32
+	DevMinor   uint32
+	_          [14]uint64
+}
+
+// Offsets into struct linux_dirent64 as returned by getdents64(2).
+const (
+	direntIno    = 0
+	direntReclen = 16
+	direntType   = 18
+	direntName   = 19
+)
+
+// d_type values from <dirent.h>.
+const (
+	dtUnknown = 0
+	dtFifo    = 1
+	dtChr     = 2
+	dtDir     = 4
+	dtBlk     = 6
+	dtReg     = 8
+	dtLnk     = 10
+	dtSock    = 12
+)
+
+// fastDirReader reads directories with raw getdents64 calls into a buffer it
+// reuses for every directory, and stats entries with statx relative to the
+// open directory so the kernel never has to resolve the full path again.
+type fastDirReader struct {
+	buf      []byte
+	names    []byte // arena for the names of the current directory
+	st       statxBuf
+	noStatx  bool   // statx is missing (kernels before 4.11)
+	mask     uint32 // statx fields to ask for
+	statDirs bool
+	noatime  bool
+}
+
+func newFastDirReader(opts walkOptions) dirReader {
+	r := &fastDirReader{buf: make([]byte, 64*1024), mask: statxMinimal, statDirs: opts.StatDirs, noatime: opts.NoATime}
+	if opts.StatTimes {
+		r.mask |= statxMtime | statxAtime
+	}
+	if opts.StatOwners {
+		r.mask |= statxUid | statxGid
+	}
+	return r
+}
+
+// direntMode maps a d_type value to the type bits of an fs.FileMode.
+func direntMode(typ byte) fs.FileMode {
+	switch typ {
+	case dtDir:
+		return fs.ModeDir
+	case dtLnk:
+		return fs.ModeSymlink
+	case dtFifo:
+		return fs.ModeNamedPipe
+	case dtSock:
+		return fs.ModeSocket
+	case dtChr:
+		return fs.ModeDevice | fs.ModeCharDevice
+	case dtBlk:
+		return fs.ModeDevice
+	}
+	return 0
+}
+
+// statxFileMode converts the st_mode bits returned by statx to an fs.FileMode.
+func statxFileMode(mode uint16) fs.FileMode {
+	m := fs.FileMode(mode & 0777)
+	switch mode & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+		m |= fs.ModeDir
+	case syscall.S_IFLNK:
+		m |= fs.ModeSymlink
+	case syscall.S_IFIFO:
+		m |= fs.ModeNamedPipe
+	case syscall.S_IFSOCK:
+		m |= fs.ModeSocket
+	case syscall.S_IFCHR:
+		m |= fs.ModeDevice | fs.ModeCharDevice
+	case syscall.S_IFBLK:
+		m |= fs.ModeDevice
+	}
+	return m
+}
+
+// mkdev encodes a device number the way glibc and Stat_t.Dev do, so that
+// devices from statx compare equal to those from the portable reader.
+func mkdev(major, minor uint32) uint64 {
+	return uint64(major&0x00000fff)<<8 | uint64(major&0xfffff000)<<32 |
+		uint64(minor&0x000000ff) | uint64(minor&0xffffff00)<<12
+}
+
+// statAt fills in the stat fields of e. name must point at a NUL-terminated
+// file name, which getdents64 already gives us inside r.buf.
+func (r *fastDirReader) statAt(dirfd int, name *byte, e *walkEntry) error {
+	if !r.noStatx {
+		_, _, errno := syscall.Syscall6(sysStatx, uintptr(dirfd), uintptr(unsafe.Pointer(name)),
+			atSymlinkNoFollow|atStatxDontSync, uintptr(r.mask), uintptr(unsafe.Pointer(&r.st)), 0)
+		switch errno {
+		case 0:
+			e.Stat = true
+			e.Mode = statxFileMode(r.st.Mode)
+			e.Size = int64(r.st.Size)
+			e.Blocks = int64(r.st.Blocks)
+			e.Dev = mkdev(r.st.DevMajor, r.st.DevMinor)
+			e.Ino = r.st.Ino
+			e.Nlink = uint64(r.st.Nlink)
+			e.Uid = r.st.Uid
+			e.Gid = r.st.Gid
+			if r.mask&statxMtime != 0 {
+				e.ModTime = time.Unix(r.st.Mtime.Sec, int64(r.st.Mtime.Nsec))
+				e.ATime = time.Unix(r.st.Atime.Sec, int64(r.st.Atime.Nsec))
+			}
+			return nil
+		case syscall.ENOSYS:
+			r.noStatx = true
+		default:
+			return errno
+		}
+	}
+	info, err := os.Lstat(e.Path())
+	if err != nil {
+		return err
+	}
+	*e = entryFromInfo(e.Dir, e.Name, e.Depth, info)
+	return nil
+}
+
+// name copies a file name into the names arena and returns a string that
+// shares its memory, in the spirit of the zero-copy conversion in
+// unsafeExample. Growing the arena leaves earlier names in the old backing
+// array, which stays alive for as long as they are referenced.
+func (r *fastDirReader) name(b []byte) string {
+	start := len(r.names)
+	r.names = append(r.names, b...)
+	return unsafe.String(&r.names[start], len(b))
+}
+
+func (r *fastDirReader) readDir(dir string, depth int, emit func(walkEntry), onError func(string, error)) {
+	flags := syscall.O_RDONLY | syscall.O_DIRECTORY | syscall.O_CLOEXEC
+	if r.noatime {
+		flags |= syscall.O_NOATIME
+	}
+	fd, err := syscall.Open(dir, flags, 0)
+	if err == syscall.EPERM && r.noatime {
+		// O_NOATIME is only allowed on directories we own.
+		fd, err = syscall.Open(dir, flags&^syscall.O_NOATIME, 0)
+	}
+	if err != nil {
+		onError(dir, &os.PathError{Op: "open", Path: dir, Err: err})
+		return
+	}
+	defer syscall.Close(fd)
+
+	r.names = r.names[:0]
+	for {
+		n, err := syscall.Getdents(fd, r.buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			onError(dir, &os.PathError{Op: "getdents64", Path: dir, Err: err})
+			return
+		}
+		if n <= 0 {
+			return
+		}
+		for off := 0; off < n; {
+			rec := r.buf[off:]
+			reclen := int(*(*uint16)(unsafe.Pointer(&rec[direntReclen])))
+			off += reclen
+			if *(*uint64)(unsafe.Pointer(&rec[direntIno])) == 0 {
+				continue // deleted entry
+			}
+			nameBytes := rec[direntName:reclen]
+			for i, c := range nameBytes {
+				if c == 0 {
+					nameBytes = nameBytes[:i]
+					break
+				}
+			}
+			if string(nameBytes) == "." || string(nameBytes) == ".." {
+				continue
+			}
+
+			e := walkEntry{Dir: dir, Name: r.name(nameBytes), Depth: depth, Mode: direntMode(rec[direntType])}
+			// d_type tells us which entries are directories, so those can
+			// skip the stat entirely. DT_UNKNOWN (some older file systems)
+			// always needs one.
+			if rec[direntType] == dtUnknown || !e.Mode.IsDir() || r.statDirs {
+				if err := r.statAt(fd, &rec[direntName], &e); err != nil {
+					path := e.Path()
+					onError(path, &os.PathError{Op: "statx", Path: path, Err: err})
+					continue
+				}
+			}
+			emit(e)
+		}
+	}
+}
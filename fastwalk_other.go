@@ -0,0 +1,9 @@
+//go:build !(linux && (amd64 || arm64))
+
+package main
+
+// newFastDirReader falls back to the portable reader where no
+// getdents64/statx fast path exists.
+func newFastDirReader(opts walkOptions) dirReader {
+	return genericDirReader{statDirs: opts.StatDirs, noatime: opts.NoATime}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// dirListing says which directories of a sizeTree to list and in what order.
+// The freshness orders and filters need a tree built with StatTimes.
+type dirListing struct {
+	Sort          string        // "size", "newest" or "oldest"
+	ChangedWithin time.Duration // only directories with a file modified this recently
+	UnchangedFor  time.Duration // only directories with no file modified this recently
+	Now           time.Time
+}
+
+// usesTimes reports whether the listing needs file modification times.
+func (l dirListing) usesTimes() bool {
+	return l.Sort != "size" || l.ChangedWithin > 0 || l.UnchangedFor > 0
+}
+
+// validate checks the listing's options, so that a typo is reported before
+// a long scan rather than after it.
+func (l dirListing) validate() error {
+	switch l.Sort {
+	case "size", "newest", "oldest":
+		return nil
+	}
+	return fmt.Errorf("invalid sort order %q: want size, newest or oldest", l.Sort)
+}
+
+// dirs returns up to n directories of t below the root, ordered and filtered
+// as asked. Directories without any files have no freshness and are left out
+// of the freshness orders and filters.
+func (l dirListing) dirs(t *sizeTree, n int) ([]uint32, error) {
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+	if !l.usesTimes() {
+		return t.largestDirs(n), nil
+	}
+
+	keep := func(i uint32) bool {
+		newest, _, _, ok := t.freshness(i)
+		if !ok {
+			return false
+		}
+		age := l.Now.Sub(newest)
+		return (l.ChangedWithin <= 0 || age < l.ChangedWithin) && (l.UnchangedFor <= 0 || age >= l.UnchangedFor)
+	}
+	less := func(a, b uint32) bool { return t.total(a) > t.total(b) }
+	switch l.Sort {
+	case "newest":
+		less = func(a, b uint32) bool { return t.times[a].newest > t.times[b].newest }
+	case "oldest":
+		// Least recently changed first: the directory whose newest file is
+		// oldest is the one nobody has touched for longest.
+		less = func(a, b uint32) bool { return t.times[a].newest < t.times[b].newest }
+	}
+	return t.topDirs(n, less, keep), nil
+}
+
+// dirReport is one directory of the JSON scan report. The times are only
+// present when the tree kept them and there are files beneath.
+type dirReport struct {
+	Path       string     `json:"path"`
+	Bytes      int64      `json:"bytes"`
+	Newest     *time.Time `json:"newest,omitempty"`
+	Oldest     *time.Time `json:"oldest,omitempty"`
+	NewestFile string     `json:"newest_file,omitempty"`
+}
+
+// dirReportOf describes node i of t.
+func dirReportOf(t *sizeTree, i uint32) dirReport {
+	d := dirReport{Path: t.path(i), Bytes: t.total(i)}
+	if newest, oldest, file, ok := t.freshness(i); ok {
+		d.Newest, d.Oldest, d.NewestFile = &newest, &oldest, t.path(file)
+	}
+	return d
+}
+
+// scanReport is what the default scan prints with -json.
+type scanReport struct {
+	Path      string            `json:"path"`
+	Bytes     int64             `json:"bytes"`
+	Dirs      []dirReport       `json:"dirs,omitempty"`
+	Histogram []histogramBucket `json:"histogram,omitempty"`
+	Entries   *inodeReport      `json:"entries,omitempty"`
+	TimedOut  []string          `json:"timed_out,omitempty"`
+}
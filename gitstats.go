@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gitCategories are the parts of a git directory the report breaks down, in
+// display order.
+var gitCategories = []string{"pack data", "pack indexes", "loose objects", "lfs", "worktrees", "reflogs", "submodules", "other"}
+
+// gitCategory classifies a file by its path relative to the git directory.
+func gitCategory(rel string) string {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	switch parts[0] {
+	case "objects":
+		if len(parts) == 3 && parts[1] == "pack" {
+			if strings.HasSuffix(parts[2], ".pack") {
+				return "pack data"
+			}
+			return "pack indexes" // .idx, .rev, .bitmap, .mtimes, .keep
+		}
+		if len(parts) == 3 && len(parts[1]) == 2 {
+			return "loose objects"
+		}
+	case "lfs":
+		return "lfs"
+	case "worktrees":
+		return "worktrees"
+	case "logs":
+		return "reflogs"
+	case "modules":
+		return "submodules"
+	}
+	return "other"
+}
+
+// gitOutput runs git with args in repo and returns its standard output.
+func gitOutput(repo string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitPath runs git rev-parse with args in repo and returns the path it
+// prints, made absolute. rev-parse --path-format=absolute would do this, but
+// only from git 2.31 on; older versions print paths relative to repo.
+func gitPath(repo string, args ...string) (string, error) {
+	path, err := gitOutput(repo, append([]string{"rev-parse"}, args...)...)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repo, path)
+	}
+	return filepath.Abs(path)
+}
+
+// gitBlob is one blob of the largest-blobs list.
+type gitBlob struct {
+	ID       string   `json:"id"`
+	Size     int64    `json:"size"`
+	DiskSize int64    `json:"disk_size"` // after delta compression in packs
+	Paths    []string `json:"paths"`
+}
+
+// blobHeap keeps the largest blobs seen, as a min-heap on size.
+type blobHeap []gitBlob
+
+func (h blobHeap) Len() int           { return len(h) }
+func (h blobHeap) Less(i, j int) bool { return h[i].Size < h[j].Size }
+func (h blobHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *blobHeap) Push(x any)        { *h = append(*h, x.(gitBlob)) }
+func (h *blobHeap) Pop() any {
+	old := *h
+	b := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return b
+}
+
+// largestBlobs lists the n largest blobs reachable from any ref, using
+// rev-list to enumerate objects with a path and cat-file to size them.
+func largestBlobs(repo string, n int) ([]gitBlob, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	// The two are connected by a pipe of our own so that neither end stays
+	// open in this process: if cat-file dies, rev-list gets EPIPE instead of
+	// blocking on a full pipe forever.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	revList := exec.Command("git", "-C", repo, "rev-list", "--objects", "--all")
+	revList.Stdout = w
+	catFile := exec.Command("git", "-C", repo, "cat-file",
+		"--batch-check=%(objecttype) %(objectname) %(objectsize) %(objectsize:disk) %(rest)")
+	catFile.Stdin = r
+	out, err := catFile.StdoutPipe()
+	if err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	err = revList.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to run git: %w", err)
+	}
+	err = catFile.Start()
+	r.Close()
+	if err != nil {
+		revList.Process.Kill()
+		revList.Wait()
+		return nil, fmt.Errorf("failed to run git: %w", err)
+	}
+
+	top := &blobHeap{}
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 5)
+		if len(fields) < 4 || fields[0] != "blob" {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[2], 10, 64)
+		disk, _ := strconv.ParseInt(fields[3], 10, 64)
+		b := gitBlob{ID: fields[1], Size: size, DiskSize: disk}
+		if len(fields) == 5 {
+			b.Paths = []string{fields[4]}
+		}
+		if top.Len() < n {
+			heap.Push(top, b)
+		} else if size > (*top)[0].Size {
+			(*top)[0] = b
+			heap.Fix(top, 0)
+		}
+	}
+	if scanner.Err() != nil {
+		// Stop cat-file rather than wait for it to finish writing.
+		catFile.Process.Kill()
+	}
+	if err := catFile.Wait(); err != nil {
+		revList.Process.Kill()
+		revList.Wait()
+		return nil, fmt.Errorf("git cat-file failed: %w", err)
+	}
+	if err := revList.Wait(); err != nil {
+		return nil, fmt.Errorf("git rev-list failed: %w", err)
+	}
+
+	blobs := []gitBlob(*top)
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Size > blobs[j].Size })
+	return blobs, addBlobPaths(repo, blobs)
+}
+
+// addBlobPaths fills in every path each blob has had in history. rev-list
+// only names the first path it meets, but the same content is often
+// renamed or copied, so the raw diffs of all commits are searched for the
+// blob IDs.
+func addBlobPaths(repo string, blobs []gitBlob) error {
+	if len(blobs) == 0 {
+		return nil
+	}
+	index := make(map[string]*gitBlob, len(blobs))
+	for i := range blobs {
+		index[blobs[i].ID] = &blobs[i]
+	}
+	cmd := exec.Command("git", "-C", repo, "log", "--all", "--format=", "--raw", "--no-abbrev", "--no-renames")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run git: %w", err)
+	}
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// :old-mode new-mode old-id new-id status<TAB>path
+		meta, path, ok := strings.Cut(scanner.Text(), "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) < 5 {
+			continue
+		}
+		if b := index[fields[3]]; b != nil {
+			found := false
+			for _, p := range b.Paths {
+				found = found || p == path
+			}
+			if !found {
+				b.Paths = append(b.Paths, path)
+			}
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git log failed: %w", err)
+	}
+	for i := range blobs {
+		sort.Strings(blobs[i].Paths)
+	}
+	return nil
+}
+
+// gitWorktree is a linked working tree checked out outside the git directory.
+type gitWorktree struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// gitReport is the result of analyzeGitRepo.
+type gitReport struct {
+	GitDir     string           `json:"git_dir"`
+	Bytes      int64            `json:"bytes"`
+	Categories map[string]int64 `json:"categories"`
+	Worktrees  []gitWorktree    `json:"linked_worktrees,omitempty"`
+	Blobs      []gitBlob        `json:"largest_blobs"`
+}
+
+// analyzeGitRepo breaks down the git directory of repo and lists its n
+// largest blobs.
+func analyzeGitRepo(repo string, n int) (*gitReport, error) {
+	gitDir, err := gitPath(repo, "--git-common-dir")
+	if err != nil {
+		return nil, err
+	}
+	r := &gitReport{GitDir: gitDir, Categories: make(map[string]int64)}
+	err = walkTree(gitDir, walkOptions{}, func(e *walkEntry) {
+		if e.Mode.IsDir() || e.Depth == 0 {
+			return
+		}
+		rel, err := filepath.Rel(gitDir, e.Path())
+		if err != nil {
+			return
+		}
+		r.Categories[gitCategory(rel)] += e.Size
+		r.Bytes += e.Size
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The checkouts of linked worktrees live elsewhere; the first entry of
+	// the list is the main working tree.
+	list, err := gitOutput(repo, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	first := true
+	for _, line := range strings.Split(list, "\n") {
+		path, ok := strings.CutPrefix(line, "worktree ")
+		if !ok {
+			continue
+		}
+		if first {
+			first = false
+			continue
+		}
+		size, err := scanDirSize(path, walkOptions{})
+		if err != nil {
+			fmt.Printf("Error accessing %s: %v\n", path, err)
+			continue
+		}
+		r.Worktrees = append(r.Worktrees, gitWorktree{path, size})
+	}
+
+	if r.Blobs, err = largestBlobs(repo, n); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// runGit implements `dirsize git [flags] [REPO]`.
+func runGit(args []string) int {
+	flags := flag.NewFlagSet("git", flag.ExitOnError)
+	top := flags.Int("top", 10, "list this many of the largest blobs")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	repo := "."
+	if flags.NArg() > 0 {
+		repo = flags.Arg(0)
+	}
+	r, err := analyzeGitRepo(repo, *top)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Printf("%s: %s\n", r.GitDir, humanReadableBytes(r.Bytes))
+	for _, c := range gitCategories {
+		fmt.Printf("%12s %5.1f%%  %s\n", humanReadableBytes(r.Categories[c]), percent(r.Categories[c], r.Bytes), c)
+	}
+	if len(r.Worktrees) > 0 {
+		fmt.Printf("\nLinked worktrees:\n")
+		for _, w := range r.Worktrees {
+			fmt.Printf("%12s  %s\n", humanReadableBytes(w.Bytes), w.Path)
+		}
+	}
+	fmt.Printf("\nLargest blobs in history (size, size on disk):\n")
+	for _, b := range r.Blobs {
+		fmt.Printf("%12s %12s  %s  %s\n", humanReadableBytes(b.Size), humanReadableBytes(b.DiskSize),
+			b.ID[:12], strings.Join(b.Paths, ", "))
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRepo creates a repository in a temporary directory and returns it
+// with a function running git in it.
+func newTestRepo(t *testing.T) (string, func(args ...string)) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null",
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	return repo, git
+}
+
+// writeRepoFile writes size bytes that do not compress well to name in repo.
+func writeRepoFile(t *testing.T, repo, name string, size int) {
+	t.Helper()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	path := filepath.Join(repo, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnalyzeGitRepo(t *testing.T) {
+	repo, git := newTestRepo(t)
+	writeRepoFile(t, repo, "small.txt", 100)
+	writeRepoFile(t, repo, "assets/big.bin", 300000)
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+	// Renaming keeps the blob, which should then be listed under both paths.
+	git("mv", "assets/big.bin", "big.bin")
+	git("commit", "-q", "-m", "rename")
+	git("rm", "-q", "big.bin")
+	git("commit", "-q", "-m", "remove")
+
+	r, err := analyzeGitRepo(repo, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filepath.IsAbs(r.GitDir) || filepath.Base(r.GitDir) != ".git" {
+		t.Errorf("git dir %q, want the absolute path of .git", r.GitDir)
+	}
+	if r.Categories["loose objects"] == 0 {
+		t.Errorf("no loose objects counted: %v", r.Categories)
+	}
+	var sum int64
+	for _, c := range gitCategories {
+		sum += r.Categories[c]
+	}
+	if sum != r.Bytes {
+		t.Errorf("categories add up to %d, want %d", sum, r.Bytes)
+	}
+	if len(r.Blobs) != 1 {
+		t.Fatalf("got %d blobs, want 1", len(r.Blobs))
+	}
+	b := r.Blobs[0]
+	if b.Size != 300000 {
+		t.Errorf("largest blob is %d bytes, want 300000", b.Size)
+	}
+	if len(b.Paths) != 2 || b.Paths[0] != "assets/big.bin" || b.Paths[1] != "big.bin" {
+		t.Errorf("largest blob paths %v, want [assets/big.bin big.bin]", b.Paths)
+	}
+
+	// Packing moves everything into pack data and indexes.
+	git("gc", "-q")
+	if r, err = analyzeGitRepo(repo, 0); err != nil {
+		t.Fatal(err)
+	}
+	if r.Categories["pack data"] == 0 || r.Categories["pack indexes"] == 0 || r.Categories["loose objects"] != 0 {
+		t.Errorf("after gc: %v", r.Categories)
+	}
+}
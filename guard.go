@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// guardAllowFile is read from the top of the working tree, if present, for
+// patterns of paths that may exceed the limits.
+const guardAllowFile = ".dirsize-guard-allow"
+
+// stagedFile is a file added or modified in the index.
+type stagedFile struct {
+	Path string
+	Blob string
+	Size int64
+}
+
+// stagedFiles lists the files whose staged content differs from HEAD, sized
+// by the blob in the index rather than by whatever is in the working tree.
+func stagedFiles(repo string) ([]stagedFile, error) {
+	// In a repository without commits there is nothing to diff against, so
+	// compare with the empty tree instead.
+	base := "HEAD"
+	if _, err := gitOutput(repo, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		if base, err = gitOutput(repo, "hash-object", "-t", "tree", os.DevNull); err != nil {
+			return nil, err
+		}
+	}
+	cmd := exec.Command("git", "-C", repo, "diff-index", "--cached", "--raw", "-z", "--no-abbrev",
+		"--no-renames", "--diff-filter=AM", base)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff-index failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// With -z every entry is ":old-mode new-mode old-id new-id status" and
+	// the path, each followed by a NUL.
+	var files []stagedFile
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		meta := strings.Fields(fields[i])
+		if len(meta) < 5 || meta[1] == "160000" {
+			continue // submodules are commits, not content
+		}
+		files = append(files, stagedFile{Path: fields[i+1], Blob: meta[3]})
+	}
+	return files, sizeBlobs(repo, files)
+}
+
+// sizeBlobs fills in the size of each file's blob with one cat-file process.
+func sizeBlobs(repo string, files []stagedFile) error {
+	if len(files) == 0 {
+		return nil
+	}
+	var ids strings.Builder
+	for _, f := range files {
+		ids.WriteString(f.Blob + "\n")
+	}
+	cmd := exec.Command("git", "-C", repo, "cat-file", "--batch-check=%(objectsize)")
+	cmd.Stdin = strings.NewReader(ids.String())
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git cat-file failed: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for i := range files {
+		if !scanner.Scan() {
+			return fmt.Errorf("git cat-file returned %d sizes for %d blobs", i, len(files))
+		}
+		if files[i].Size, err = strconv.ParseInt(scanner.Text(), 10, 64); err != nil {
+			return fmt.Errorf("failed to size %s: %s", files[i].Path, scanner.Text())
+		}
+	}
+	return nil
+}
+
+// guardAllowed reports whether name, a slash-separated path relative to the
+// top of the repository, is matched by one of patterns. A pattern matches
+// the whole path or, if it has no slash, just the base name, like in
+// .gitignore; a pattern ending in a slash matches everything beneath that
+// directory.
+func guardAllowed(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if dir, ok := strings.CutSuffix(p, "/"); ok {
+			if strings.HasPrefix(name, dir+"/") {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+		if !strings.Contains(p, "/") {
+			if ok, _ := path.Match(p, path.Base(name)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readGuardPatterns reads one pattern per line from path, skipping blank
+// lines and # comments. A missing file is not an error.
+func readGuardPatterns(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, strings.TrimPrefix(line, "/"))
+	}
+	return patterns, nil
+}
+
+// guardResult is the outcome of checkStaged.
+type guardResult struct {
+	TooLarge  []stagedFile // over the file limit and not allowed
+	Allowed   []stagedFile // over the file limit but allowed
+	Total     int64        // of the files not allowed
+	Counted   int          // files not allowed
+	OverTotal bool
+}
+
+// ok reports whether the staged changes are within the limits.
+func (r guardResult) ok() bool {
+	return len(r.TooLarge) == 0 && !r.OverTotal
+}
+
+// checkStaged sizes the staged changes of repo against the limits. Files
+// matching one of patterns are exempt from both. A limit of 0 means none.
+func checkStaged(repo string, fileLimit, totalLimit int64, patterns []string) (guardResult, error) {
+	var r guardResult
+	files, err := stagedFiles(repo)
+	if err != nil {
+		return r, err
+	}
+	over := func(f stagedFile) bool { return fileLimit > 0 && f.Size > fileLimit }
+	for _, f := range files {
+		if guardAllowed(f.Path, patterns) {
+			if over(f) {
+				r.Allowed = append(r.Allowed, f)
+			}
+			continue
+		}
+		r.Total += f.Size
+		r.Counted++
+		if over(f) {
+			r.TooLarge = append(r.TooLarge, f)
+		}
+	}
+	r.OverTotal = totalLimit > 0 && r.Total > totalLimit
+	return r, nil
+}
+
+// installGuardHook writes a pre-commit hook that runs dirsize guard with
+// args. An existing hook is left alone.
+func installGuardHook(repo string, args []string) error {
+	hook, err := gitPath(repo, "--git-path", "hooks/pre-commit")
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(hook); err == nil {
+		return fmt.Errorf("'%s' already exists; add `dirsize guard` to it by hand", hook)
+	}
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	script := "#!/bin/sh\n# Installed by dirsize guard -install.\nexec dirsize guard " + strings.Join(quoted, " ") + "\n"
+	if err := os.MkdirAll(filepath.Dir(hook), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(hook, []byte(script), 0755); err != nil {
+		return fmt.Errorf("failed to write hook: %w", err)
+	}
+	fmt.Printf("Installed %s\n", hook)
+	return nil
+}
+
+// runGuard implements `dirsize guard [flags]`. It exits with 1 when the
+// staged changes break a limit, so that it can be used as a pre-commit hook.
+func runGuard(args []string) int {
+	flags := flag.NewFlagSet("guard", flag.ExitOnError)
+	maxFile := flags.String("max-file", "5M", "largest size allowed for a single staged file (0 for no limit)")
+	maxTotal := flags.String("max-total", "20M", "largest size allowed for all staged files together (0 for no limit)")
+	allow := flags.String("allow", "", "comma-separated patterns of paths exempt from the limits")
+	allowFile := flags.String("allow-file", "", "file with one exempt pattern per line (default "+guardAllowFile+" at the top of the repository)")
+	repo := flags.String("C", ".", "run in this repository")
+	install := flags.Bool("install", false, "install a pre-commit hook running guard with the other flags given")
+	flags.Parse(args)
+
+	fileLimit, err := parseByteSize(*maxFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	totalLimit, err := parseByteSize(*maxTotal)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *install {
+		var hookArgs []string
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name != "install" && f.Name != "C" {
+				hookArgs = append(hookArgs, "-"+f.Name+"="+f.Value.String())
+			}
+		})
+		if err := installGuardHook(*repo, hookArgs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	var patterns []string
+	for _, p := range strings.Split(*allow, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	listPath := *allowFile
+	if listPath == "" {
+		top, err := gitOutput(*repo, "rev-parse", "--show-toplevel")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		listPath = filepath.Join(top, guardAllowFile)
+	}
+	listed, err := readGuardPatterns(listPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	patterns = append(patterns, listed...)
+
+	r, err := checkStaged(*repo, fileLimit, totalLimit, patterns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, f := range r.Allowed {
+		fmt.Printf("dirsize guard: allowing %s (%s)\n", f.Path, humanReadableBytes(f.Size))
+	}
+	if r.ok() {
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "dirsize guard: the staged changes are too large.\n\n")
+	if len(r.TooLarge) > 0 {
+		fmt.Fprintf(os.Stderr, "Files over the limit of %s:\n", humanReadableBytes(fileLimit))
+		for _, f := range r.TooLarge {
+			fmt.Fprintf(os.Stderr, "%12s  %s\n", humanReadableBytes(f.Size), f.Path)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	if r.OverTotal {
+		fmt.Fprintf(os.Stderr, "The %d staged files add up to %s, over the limit of %s.\n\n",
+			r.Counted, humanReadableBytes(r.Total), humanReadableBytes(totalLimit))
+	}
+	fmt.Fprintf(os.Stderr, "Unstage them with `git restore --staged <path>`, exempt them with -allow or\n")
+	fmt.Fprintf(os.Stderr, "a pattern in %s, or skip the check once with `git commit --no-verify`.\n", guardAllowFile)
+	return 1
+}
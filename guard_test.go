@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func stagedPaths(files []stagedFile) []string {
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+func TestCheckStaged(t *testing.T) {
+	repo, git := newTestRepo(t)
+
+	// Nothing has been committed yet, so everything staged is new.
+	writeRepoFile(t, repo, "small.txt", 100)
+	writeRepoFile(t, repo, "media/video.mp4", 3000)
+	git("add", "-A")
+	r, err := checkStaged(repo, 1000, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ok() || len(r.TooLarge) != 1 || r.TooLarge[0].Path != "media/video.mp4" || r.TooLarge[0].Size != 3000 {
+		t.Errorf("too large: %v, want media/video.mp4 of 3000 bytes", r.TooLarge)
+	}
+	if r.Total != 3100 || r.Counted != 2 {
+		t.Errorf("total %d bytes in %d files, want 3100 in 2", r.Total, r.Counted)
+	}
+
+	for _, patterns := range [][]string{{"media/"}, {"*.mp4"}, {"media/*.mp4"}} {
+		if r, err = checkStaged(repo, 1000, 0, patterns); err != nil {
+			t.Fatal(err)
+		}
+		if !r.ok() || len(r.Allowed) != 1 || r.Total != 100 {
+			t.Errorf("allow %v: too large %v, allowed %v, total %d", patterns, r.TooLarge, r.Allowed, r.Total)
+		}
+	}
+
+	// 0 means no limit, for the single files as for the total.
+	if r, err = checkStaged(repo, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !r.ok() || len(r.Allowed) != 0 {
+		t.Errorf("without limits: too large %v, allowed %v", r.TooLarge, r.Allowed)
+	}
+	if r, err = checkStaged(repo, 0, 3000, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !r.OverTotal || len(r.TooLarge) != 0 {
+		t.Errorf("total limit: over %v, too large %v", r.OverTotal, r.TooLarge)
+	}
+
+	// After a commit only changes count, and what is staged counts rather
+	// than what is in the working tree.
+	git("commit", "-q", "-m", "first")
+	writeRepoFile(t, repo, "small.txt", 2000)
+	git("add", "small.txt")
+	writeRepoFile(t, repo, "small.txt", 10)
+	if err := os.Remove(filepath.Join(repo, "media/video.mp4")); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "-A")
+	if r, err = checkStaged(repo, 1000, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if r.Counted != 1 || r.Total != 10 || !r.ok() {
+		t.Errorf("after commit: %d files of %d bytes, too large %v; want small.txt of 10 bytes",
+			r.Counted, r.Total, stagedPaths(r.TooLarge))
+	}
+}
+
+func TestGuardAllowed(t *testing.T) {
+	tests := []struct {
+		path     string
+		patterns []string
+		want     bool
+	}{
+		{"a/b/c.bin", []string{"*.bin"}, true},
+		{"a/b/c.bin", []string{"a/"}, true},
+		{"a/b/c.bin", []string{"b/"}, false},
+		{"a/b/c.bin", []string{"a/*.bin"}, false},
+		{"a/b/c.bin", []string{"a/b/*.bin"}, true},
+		{"c.bin", []string{"*.txt", "c.*"}, true},
+	}
+	for _, tt := range tests {
+		if got := guardAllowed(tt.path, tt.patterns); got != tt.want {
+			t.Errorf("guardAllowed(%q, %q) = %v, want %v", tt.path, tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestReadGuardPatterns(t *testing.T) {
+	list := filepath.Join(t.TempDir(), guardAllowFile)
+	if patterns, err := readGuardPatterns(list); err != nil || patterns != nil {
+		t.Errorf("missing file: %q, %v; want no patterns and no error", patterns, err)
+	}
+	if err := os.WriteFile(list, []byte("# large assets\n\n/media/\n  *.psd \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := readGuardPatterns(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patterns) != 2 || patterns[0] != "media/" || patterns[1] != "*.psd" {
+		t.Errorf("patterns %q, want [media/ *.psd]", patterns)
+	}
+}
+
+func TestInstallGuardHook(t *testing.T) {
+	repo, _ := newTestRepo(t)
+	if err := installGuardHook(repo, []string{"-max-file=1M", "-allow=it's/"}); err != nil {
+		t.Fatal(err)
+	}
+	hook := filepath.Join(repo, ".git", "hooks", "pre-commit")
+	script, err := os.ReadFile(hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `exec dirsize guard '-max-file=1M' '-allow=it'\''s/'` + "\n"; !strings.HasSuffix(string(script), want) {
+		t.Errorf("hook is %q, want it to end with %q", script, want)
+	}
+
+	// An existing hook is never overwritten.
+	if err := installGuardHook(repo, nil); err == nil {
+		t.Error("installing over an existing hook succeeded")
+	}
+	if again, _ := os.ReadFile(hook); !bytes.Equal(again, script) {
+		t.Errorf("existing hook was changed to %q", again)
+	}
+}
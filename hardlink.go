@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// linkJournalEntry records one file that was replaced by a hard link, with
+// enough about the original to turn it back into an independent copy.
+type linkJournalEntry struct {
+	Path    string      `json:"path"`
+	Target  string      `json:"linked_to"`
+	Size    int64       `json:"size"`
+	SHA256  string      `json:"sha256"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+}
+
+// filesEqual compares two files byte for byte.
+func filesEqual(a, b string) (bool, error) {
+	fa, err := openNoATime(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := openNoATime(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, 64*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// replaceWithLink atomically replaces path with a hard link to master: the
+// link is created under a temporary name in the same directory and then
+// renamed over path, so path never stops existing.
+func replaceWithLink(master, path string) error {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".dirsize-link-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	if err := os.Link(master, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// linkAction is one copy linkDuplicates linked, or would have linked, to
+// Target, or skipped for the reason given.
+type linkAction struct {
+	Path    string `json:"path"`
+	Target  string `json:"target,omitempty"`
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// linkResult summarizes a linkDuplicates run.
+type linkResult struct {
+	Linked  int          `json:"linked"`
+	Saved   int64        `json:"saved"`
+	Skipped int          `json:"skipped"`
+	Actions []linkAction `json:"actions"`
+}
+
+// skip records a copy that is left alone.
+func (r *linkResult) skip(path, reason string) {
+	r.Actions = append(r.Actions, linkAction{Path: path, Skipped: reason})
+	r.Skipped++
+}
+
+// journalLink appends the entry for replacing path with a link to target
+// to the journal and syncs it to disk. Paths are stored absolute, so the
+// journal can be undone from any working directory.
+func journalLink(journal *os.File, path, target string, info fs.FileInfo, sha string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(linkJournalEntry{
+		Path: absPath, Target: absTarget, Size: info.Size(), SHA256: sha,
+		Mode: info.Mode(), ModTime: info.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write undo journal: %w", err)
+	}
+	if err := journal.Sync(); err != nil {
+		return fmt.Errorf("failed to write undo journal: %w", err)
+	}
+	return nil
+}
+
+// linkDuplicates replaces the copies in every duplicate set with hard links,
+// one set per file system. Within a set the copy with the oldest modification
+// time is kept as the master, so the shared inode keeps the oldest mtime.
+// Copies whose permissions or owner differ from the master's are skipped, as
+// linking would silently change them. Every copy is compared byte for byte
+// with the master right before it is replaced; the hashes may be stale by
+// then. With dryRun nothing is changed and the journal, which may then be
+// nil, is not written.
+//
+// Each journal entry is written and synced before its copy is replaced, so
+// that after a crash every link that exists is in the journal. An entry whose
+// replacement then failed is harmless: undoing it copies the untouched file
+// onto itself.
+func linkDuplicates(sets []duplicateSet, dryRun bool, journal *os.File) (linkResult, error) {
+	res := linkResult{Actions: []linkAction{}}
+	for _, set := range sets {
+		type candidate struct {
+			path string
+			info fs.FileInfo
+			dev  uint64
+		}
+		byDev := make(map[uint64][]candidate)
+		for _, path := range set.Paths {
+			info, err := os.Lstat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				res.skip(path, "no longer a regular file")
+				continue
+			}
+			var e walkEntry
+			fillSysInfo(&e, info)
+			byDev[e.Dev] = append(byDev[e.Dev], candidate{path, info, e.Dev})
+		}
+
+		for _, group := range byDev {
+			if len(group) < 2 {
+				continue
+			}
+			master := group[0]
+			for _, c := range group[1:] {
+				if c.info.ModTime().Before(master.info.ModTime()) {
+					master = c
+				}
+			}
+			for _, c := range group {
+				if c.path == master.path || os.SameFile(c.info, master.info) {
+					continue
+				}
+				if c.info.Mode() != master.info.Mode() || !sameOwner(c.info, master.info) {
+					res.skip(c.path, "permissions or owner differ from "+master.path)
+					continue
+				}
+				equal, err := filesEqual(master.path, c.path)
+				if err != nil || !equal {
+					res.skip(c.path, "content no longer matches "+master.path)
+					continue
+				}
+				if !dryRun {
+					if err := journalLink(journal, c.path, master.path, c.info, set.SHA256); err != nil {
+						return res, err
+					}
+					if err := replaceWithLink(master.path, c.path); err != nil {
+						return res, fmt.Errorf("failed to link %s to %s: %w", c.path, master.path, err)
+					}
+				}
+				res.Actions = append(res.Actions, linkAction{Path: c.path, Target: master.path})
+				res.Linked++
+				res.Saved += c.info.Size()
+			}
+		}
+	}
+	return res, nil
+}
+
+// undoLinks reads an undo journal and turns every linked path back into an
+// independent copy with its original mode and modification time. Each copy is
+// written to a temporary name and renamed into place.
+func undoLinks(journalPath string) (int, error) {
+	f, err := os.Open(journalPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	restored := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e linkJournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return restored, fmt.Errorf("invalid journal line %q: %w", scanner.Text(), err)
+		}
+		tmp := filepath.Join(filepath.Dir(e.Path), fmt.Sprintf(".dirsize-undo-%d-%d", os.Getpid(), time.Now().UnixNano()))
+		if err := copyFileTo(e.Path, tmp, e.Mode); err != nil {
+			return restored, fmt.Errorf("failed to copy %s: %w", e.Path, err)
+		}
+		// The umask may have narrowed the mode the copy was created with,
+		// and it never carries setuid, setgid or sticky bits.
+		if err := os.Chmod(tmp, e.Mode); err != nil {
+			os.Remove(tmp)
+			return restored, fmt.Errorf("failed to restore the mode of %s: %w", e.Path, err)
+		}
+		if err := os.Chtimes(tmp, e.ModTime, e.ModTime); err != nil {
+			os.Remove(tmp)
+			return restored, fmt.Errorf("failed to restore the modification time of %s: %w", e.Path, err)
+		}
+		if err := os.Rename(tmp, e.Path); err != nil {
+			os.Remove(tmp)
+			return restored, fmt.Errorf("failed to restore %s: %w", e.Path, err)
+		}
+		restored++
+	}
+	return restored, scanner.Err()
+}
+
+// copyFileTo copies src to a new file dst created with the given
+// permissions, less the umask.
+func copyFileTo(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// histogramBarWidth is the width of each bar in the terminal chart.
+const histogramBarWidth = 30
+
+// sizeHistogram counts files in power-of-two size buckets: bucket 0 holds
+// empty files and bucket k > 0 sizes from 2^(k-1) up to but not including
+// 2^k, which is exactly bits.Len64 of the size.
+type sizeHistogram struct {
+	files [65]int64
+	bytes [65]int64
+}
+
+// visit is a walkOptions.OnEntry hook, so the histogram is filled in the
+// same pass that computes the total.
+func (h *sizeHistogram) visit(e *walkEntry) {
+	if e.Mode.IsDir() {
+		return
+	}
+	k := bits.Len64(uint64(e.Size))
+	h.files[k]++
+	h.bytes[k] += e.Size
+}
+
+// histogramBucket is one bucket of the JSON report. Max is exclusive.
+type histogramBucket struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max"`
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// bucketBounds returns the smallest size in bucket k and the smallest one
+// past it.
+func bucketBounds(k int) (int64, int64) {
+	if k == 0 {
+		return 0, 1
+	}
+	hi := int64(-1) // 2^63 does not fit; only reachable by a corrupt stat
+	if k < 64 {
+		hi = 1 << k
+	}
+	return 1 << (k - 1), hi
+}
+
+// buckets returns the buckets from the smallest to the largest non-empty
+// one, so gaps in between stay visible.
+func (h *sizeHistogram) buckets() []histogramBucket {
+	first, last := -1, -1
+	for k := range h.files {
+		if h.files[k] > 0 {
+			if first < 0 {
+				first = k
+			}
+			last = k
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	out := make([]histogramBucket, 0, last-first+1)
+	for k := first; k <= last; k++ {
+		lo, hi := bucketBounds(k)
+		out = append(out, histogramBucket{Min: lo, Max: hi, Files: h.files[k], Bytes: h.bytes[k]})
+	}
+	return out
+}
+
+// histogramBar draws value as a share of largest.
+func histogramBar(value, largest int64) string {
+	if largest == 0 {
+		return ""
+	}
+	n := int((value*histogramBarWidth + largest - 1) / largest)
+	return strings.Repeat("#", n)
+}
+
+// print renders the histogram as a bar chart of file counts next to one of
+// bytes.
+func (h *sizeHistogram) print() {
+	buckets := h.buckets()
+	var maxFiles, maxBytes, totalFiles, totalBytes int64
+	for _, b := range buckets {
+		maxFiles, maxBytes = max(maxFiles, b.Files), max(maxBytes, b.Bytes)
+		totalFiles += b.Files
+		totalBytes += b.Bytes
+	}
+
+	fmt.Printf("\nFile size distribution:\n")
+	fmt.Printf("%23s  %10s %6s  %*s  %10s\n", "SIZE", "FILES", "", histogramBarWidth, "", "BYTES")
+	for _, b := range buckets {
+		label := "0 bytes"
+		if b.Min > 0 {
+			label = fmt.Sprintf("%s - %s", humanReadableBytes(b.Min), humanReadableBytes(b.Max))
+			if b.Max < 0 {
+				label = humanReadableBytes(b.Min) + " -"
+			}
+		}
+		fmt.Printf("%23s  %10d %5.1f%%  %-*s  %10s %5.1f%%  %s\n", label,
+			b.Files, percent(b.Files, totalFiles), histogramBarWidth, histogramBar(b.Files, maxFiles),
+			humanReadableBytes(b.Bytes), percent(b.Bytes, totalBytes), histogramBar(b.Bytes, maxBytes))
+	}
+}
+
+// percent returns part as a percentage of whole, or 0 for an empty whole.
+func percent(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(whole)
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// fillSysInfo has no inode information to offer outside Unix; every file is
+// treated as its own single link.
+func fillSysInfo(e *walkEntry, info fs.FileInfo) {
+	e.Nlink = 1
+}
+
+// sameOwner has no owners to compare outside Unix.
+func sameOwner(a, b fs.FileInfo) bool {
+	return true
+}
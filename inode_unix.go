@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fillSysInfo copies the device, inode number, link count, owner and
+// allocated 512-byte blocks from an Lstat result into e.
+func fillSysInfo(e *walkEntry, info fs.FileInfo) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		e.Nlink = 1
+		return
+	}
+	e.Dev = uint64(st.Dev)
+	e.Ino = uint64(st.Ino)
+	e.Nlink = uint64(st.Nlink)
+	e.Blocks = int64(st.Blocks)
+	e.Uid = st.Uid
+	e.Gid = st.Gid
+}
+
+// sameOwner reports whether two files have the same owner and group.
+func sameOwner(a, b fs.FileInfo) bool {
+	sa, okA := a.Sys().(*syscall.Stat_t)
+	sb, okB := b.Sys().(*syscall.Stat_t)
+	return okA && okB && sa.Uid == sb.Uid && sa.Gid == sb.Gid
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// entryCounts counts directory entries by type. Hard links are counted once
+// per name, as ls and find see them, so the total can exceed the inodes used.
+type entryCounts struct {
+	Files    int64 `json:"files"`
+	Dirs     int64 `json:"dirs"`
+	Symlinks int64 `json:"symlinks"`
+	Other    int64 `json:"other"`
+}
+
+func (c *entryCounts) total() int64 {
+	return c.Files + c.Dirs + c.Symlinks + c.Other
+}
+
+func (c *entryCounts) add(other entryCounts) {
+	c.Files += other.Files
+	c.Dirs += other.Dirs
+	c.Symlinks += other.Symlinks
+	c.Other += other.Other
+}
+
+// dirEntries holds the counts of one directory: direct holds its own
+// entries, and recursive everything beneath it once rolled up.
+type dirEntries struct {
+	direct    entryCounts
+	recursive entryCounts
+}
+
+// inodeCollector counts entries per directory as a walkOptions.OnEntry hook.
+// Directories are keyed by path; the walker hands all entries of a directory
+// the same Dir string, so keys are not allocated per entry.
+type inodeCollector struct {
+	root  string
+	dirs  map[string]*dirEntries
+	total entryCounts // including the root itself
+}
+
+func newInodeCollector(root string) *inodeCollector {
+	return &inodeCollector{root: filepath.Clean(root), dirs: make(map[string]*dirEntries)}
+}
+
+func (c *inodeCollector) dir(path string) *dirEntries {
+	d, ok := c.dirs[path]
+	if !ok {
+		d = &dirEntries{}
+		c.dirs[path] = d
+	}
+	return d
+}
+
+func (c *inodeCollector) visit(e *walkEntry) {
+	var one entryCounts
+	switch {
+	case e.Mode.IsDir():
+		one.Dirs = 1
+		c.dir(filepath.Clean(e.Path())) // so empty directories are listed too
+	case e.Mode.IsRegular():
+		one.Files = 1
+	case e.Mode&fs.ModeSymlink != 0:
+		one.Symlinks = 1
+	default:
+		one.Other = 1
+	}
+	c.total.add(one)
+	if e.Depth == 0 {
+		return
+	}
+	dir := e.Dir
+	if e.Depth == 1 {
+		dir = c.root // as given, the root may not be clean
+	}
+	c.dir(dir).direct.add(one)
+}
+
+// finish rolls the direct counts up into recursive ones, deepest first.
+func (c *inodeCollector) finish() {
+	paths := make([]string, 0, len(c.dirs))
+	for path, d := range c.dirs {
+		d.recursive = d.direct
+		paths = append(paths, path)
+	}
+	sep := string(filepath.Separator)
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.Count(paths[i], sep) > strings.Count(paths[j], sep)
+	})
+	for _, path := range paths {
+		if path == c.root {
+			continue
+		}
+		if parent, ok := c.dirs[filepath.Dir(path)]; ok {
+			parent.recursive.add(c.dirs[path].recursive)
+		}
+	}
+}
+
+// dirCount is one directory in the inode report.
+type dirCount struct {
+	Path string `json:"path"`
+	entryCounts
+	Entries int64 `json:"entries"`
+}
+
+// inodeReport is the JSON form of the collected counts.
+type inodeReport struct {
+	Total        entryCounts `json:"total"`
+	FSInodes     uint64      `json:"fs_inodes,omitempty"`
+	FSInodesFree uint64      `json:"fs_inodes_free,omitempty"`
+	MostEntries  []dirCount  `json:"most_entries"`
+	LargestFlat  []dirCount  `json:"largest_flat"`
+}
+
+// report returns the totals and the n directories with the most entries,
+// both recursive and direct. The root is left out of both lists.
+func (c *inodeCollector) report(n int) inodeReport {
+	r := inodeReport{Total: c.total}
+	if st, err := statFS(c.root); err == nil {
+		r.FSInodes, r.FSInodesFree = st.Inodes, st.InodesFree
+	}
+	top := func(counts func(*dirEntries) entryCounts) []dirCount {
+		var out []dirCount
+		for path, d := range c.dirs {
+			if path != c.root {
+				ec := counts(d)
+				out = append(out, dirCount{path, ec, ec.total()})
+			}
+		}
+		sort.Slice(out, func(i, j int) bool {
+			if out[i].Entries != out[j].Entries {
+				return out[i].Entries > out[j].Entries
+			}
+			return out[i].Path < out[j].Path
+		})
+		if len(out) > n {
+			out = out[:n]
+		}
+		return out
+	}
+	r.MostEntries = top(func(d *dirEntries) entryCounts { return d.recursive })
+	r.LargestFlat = top(func(d *dirEntries) entryCounts { return d.direct })
+	return r
+}
+
+// print writes the inode report below the size total.
+func (r inodeReport) print() {
+	t := r.Total
+	fmt.Printf("\nEntries: %d files, %d directories, %d symlinks, %d other (%d total)\n",
+		t.Files, t.Dirs, t.Symlinks, t.Other, t.total())
+	if r.FSInodes > 0 {
+		used := r.FSInodes - r.FSInodesFree
+		fmt.Printf("File system inodes: %d of %d used (%.1f%%), %d free; this tree accounts for %.1f%% of those used\n",
+			used, r.FSInodes, percent(int64(used), int64(r.FSInodes)), r.FSInodesFree, percent(t.total(), int64(used)))
+	}
+	for _, list := range []struct {
+		title string
+		dirs  []dirCount
+	}{
+		{"Most entries (recursive)", r.MostEntries},
+		{"Most direct entries", r.LargestFlat},
+	} {
+		fmt.Printf("\n%s:\n", list.title)
+		for _, d := range list.dirs {
+			fmt.Printf("%12d  %s (%d files, %d dirs, %d symlinks, %d other)\n",
+				d.Entries, d.Path, d.Files, d.Dirs, d.Symlinks, d.Other)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// junkRule recognizes one kind of regenerable directory. Name is matched
+// against the directory's base name (filepath.Match patterns allowed). If
+// Markers is set, one of them must sit next to the directory, such as the
+// package.json beside node_modules; if Contains is set, one of them must be
+// inside it, such as the pyvenv.cfg of a virtualenv. Rules without either
+// always match.
+type junkRule struct {
+	Category string   `json:"category"`
+	Name     string   `json:"name"`
+	Markers  []string `json:"markers,omitempty"`
+	Contains []string `json:"contains,omitempty"`
+}
+
+// defaultJunkRules is the built-in catalogue. More rules can be added from a
+// JSON file with -rules.
+var defaultJunkRules = []junkRule{
+	{Category: "node", Name: "node_modules", Markers: []string{"package.json"}},
+	{Category: "node", Name: ".next", Markers: []string{"package.json"}},
+	{Category: "node", Name: ".nuxt", Markers: []string{"package.json"}},
+	{Category: "node", Name: ".parcel-cache", Markers: []string{"package.json"}},
+	{Category: "rust", Name: "target", Markers: []string{"Cargo.toml"}},
+	{Category: "java", Name: "target", Markers: []string{"pom.xml"}},
+	{Category: "java", Name: ".gradle", Markers: []string{"build.gradle", "build.gradle.kts", "settings.gradle", "settings.gradle.kts"}},
+	{Category: "java", Name: "build", Markers: []string{"build.gradle", "build.gradle.kts"}},
+	{Category: "python", Name: ".venv", Contains: []string{"pyvenv.cfg"}},
+	{Category: "python", Name: "venv", Contains: []string{"pyvenv.cfg"}},
+	{Category: "python", Name: "__pycache__"},
+	{Category: "python", Name: ".pytest_cache"},
+	{Category: "python", Name: ".mypy_cache"},
+	{Category: "python", Name: ".ruff_cache"},
+	{Category: "python", Name: ".tox", Markers: []string{"tox.ini", "pyproject.toml", "setup.py"}},
+	{Category: "go", Name: "go-build", Contains: []string{"trim.txt"}},
+	{Category: "dotnet", Name: "bin", Markers: []string{"*.csproj", "*.fsproj"}},
+	{Category: "dotnet", Name: "obj", Markers: []string{"*.csproj", "*.fsproj"}},
+	{Category: "cmake", Name: "cmake-build-*", Markers: []string{"CMakeLists.txt"}},
+	{Category: "terraform", Name: ".terraform", Markers: []string{"*.tf"}},
+	{Category: "docker", Name: ".buildx-cache", Contains: []string{"index.json"}},
+}
+
+// loadJunkRules reads extra rules from a JSON array in path.
+func loadJunkRules(path string) ([]junkRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []junkRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules file '%s': %w", path, err)
+	}
+	for _, r := range rules {
+		if _, err := filepath.Match(r.Name, ""); err != nil || r.Name == "" || r.Category == "" {
+			return nil, fmt.Errorf("invalid rule %+v in '%s': need a category and a valid name pattern", r, path)
+		}
+	}
+	return rules, nil
+}
+
+// anyNameMatches reports whether one of names matches one of patterns.
+func anyNameMatches(names []string, patterns []string) bool {
+	for _, p := range patterns {
+		for _, name := range names {
+			if ok, _ := filepath.Match(p, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// junkDir is one directory found to be regenerable.
+type junkDir struct {
+	Path     string `json:"path"`
+	Project  string `json:"project"`
+	Category string `json:"category"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// findJunk walks root and returns the directories matched by rules,
+// largest first. Nothing beneath a match is looked at again, so the
+// node_modules inside node_modules are part of the outer one.
+func findJunk(root string, rules []junkRule, opts walkOptions) ([]junkDir, error) {
+	tree, err := buildSizeTree(root, opts)
+	if err != nil {
+		return nil, err
+	}
+	kids := newChildIndex(tree)
+	namesOf := func(i uint32) []string {
+		children := kids.children(i)
+		names := make([]string, len(children))
+		for k, c := range children {
+			names[k] = tree.name(c)
+		}
+		return names
+	}
+
+	var found []junkDir
+	matched := make([]bool, len(tree.nodes))
+	for i := 1; i < len(tree.nodes); i++ {
+		node := &tree.nodes[i]
+		if matched[node.parent] {
+			matched[i] = true
+			continue
+		}
+		if !node.mode.IsDir() {
+			continue
+		}
+		name := tree.name(uint32(i))
+		for _, r := range rules {
+			if ok, _ := filepath.Match(r.Name, name); !ok {
+				continue
+			}
+			if len(r.Markers) > 0 && !anyNameMatches(namesOf(node.parent), r.Markers) {
+				continue
+			}
+			if len(r.Contains) > 0 && !anyNameMatches(namesOf(uint32(i)), r.Contains) {
+				continue
+			}
+			matched[i] = true
+			found = append(found, junkDir{
+				Path:     tree.path(uint32(i)),
+				Project:  tree.path(node.parent),
+				Category: r.Category,
+				Bytes:    tree.total(uint32(i)),
+			})
+			break
+		}
+	}
+	sort.Slice(found, func(a, b int) bool {
+		if found[a].Bytes != found[b].Bytes {
+			return found[a].Bytes > found[b].Bytes
+		}
+		return found[a].Path < found[b].Path
+	})
+	return found, nil
+}
+
+// junkTotal sums up the junk directories sharing a category or project.
+type junkTotal struct {
+	Key   string
+	Bytes int64
+	Dirs  int
+}
+
+// sumBy totals the junk by the key returned for each directory, largest
+// first.
+func sumBy(dirs []junkDir, key func(junkDir) string) []junkTotal {
+	totals := make(map[string]*junkTotal)
+	var order []string
+	for _, d := range dirs {
+		k := key(d)
+		t, ok := totals[k]
+		if !ok {
+			t = &junkTotal{Key: k}
+			totals[k] = t
+			order = append(order, k)
+		}
+		t.Bytes += d.Bytes
+		t.Dirs++
+	}
+	out := make([]junkTotal, 0, len(order))
+	for _, k := range order {
+		out = append(out, *totals[k])
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Bytes > out[j].Bytes })
+	return out
+}
+
+// runJunk implements `dirsize junk [flags] [DIR]`.
+func runJunk(args []string) int {
+	flags := flag.NewFlagSet("junk", flag.ExitOnError)
+	rulesPath := flags.String("rules", "", "JSON file with extra rules: [{\"category\", \"name\", \"markers\", \"contains\"}]")
+	only := flags.String("only", "", "comma-separated categories to report (default all)")
+	minSize := flags.String("min-size", "1M", "ignore directories smaller than this")
+	top := flags.Int("top", 20, "list at most this many projects")
+	clean := flags.Bool("clean", false, "delete the directories found")
+	dryRun := flags.Bool("dry-run", false, "with -clean, only show what would be deleted")
+	asJSON := flags.Bool("json", false, "print the directories found as JSON")
+	flags.Parse(args)
+
+	if *dryRun && !*clean {
+		fmt.Fprintln(os.Stderr, "dirsize junk: -dry-run only applies to -clean")
+		return 2
+	}
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	limit, err := parseByteSize(*minSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	rules := defaultJunkRules
+	if *rulesPath != "" {
+		extra, err := loadJunkRules(*rulesPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		rules = append(extra, rules...) // user rules take precedence
+	}
+	if *only != "" {
+		wanted := make(map[string]bool)
+		for _, c := range strings.Split(*only, ",") {
+			wanted[strings.TrimSpace(c)] = true
+		}
+		var kept []junkRule
+		for _, r := range rules {
+			if wanted[r.Category] {
+				kept = append(kept, r)
+			}
+		}
+		rules = kept
+	}
+
+	found, err := findJunk(root, rules, walkOptions{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var dirs []junkDir
+	var total int64
+	for _, d := range found {
+		if d.Bytes >= limit {
+			dirs = append(dirs, d)
+			total += d.Bytes
+		}
+	}
+
+	if *clean {
+		var freed int64
+		failed := 0
+		for _, d := range dirs {
+			if *dryRun {
+				fmt.Printf("Would delete %s (%s)\n", d.Path, humanReadableBytes(d.Bytes))
+				freed += d.Bytes
+				continue
+			}
+			if err := os.RemoveAll(d.Path); err != nil {
+				fmt.Printf("Error deleting %s: %v\n", d.Path, err)
+				failed++
+				continue
+			}
+			fmt.Printf("Deleted %s (%s)\n", d.Path, humanReadableBytes(d.Bytes))
+			freed += d.Bytes
+		}
+		verb := "Freed"
+		if *dryRun {
+			verb = "Would free"
+		}
+		fmt.Printf("\n%s %s in %d directories\n", verb, humanReadableBytes(freed), len(dirs)-failed)
+		if failed > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(dirs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Printf("By category:\n")
+	for _, c := range sumBy(dirs, func(d junkDir) string { return d.Category }) {
+		fmt.Printf("%12s  %-10s (%d directories)\n", humanReadableBytes(c.Bytes), c.Key, c.Dirs)
+	}
+	fmt.Printf("\nBy project:\n")
+	byProject := make(map[string][]junkDir)
+	for _, d := range dirs {
+		byProject[d.Project] = append(byProject[d.Project], d)
+	}
+	for i, p := range sumBy(dirs, func(d junkDir) string { return d.Project }) {
+		if i == *top {
+			fmt.Printf("  ... and %d more projects\n", len(byProject)-*top)
+			break
+		}
+		fmt.Printf("%12s  %s\n", humanReadableBytes(p.Bytes), p.Key)
+		for _, d := range byProject[p.Key] {
+			fmt.Printf("%12s    %s [%s]\n", humanReadableBytes(d.Bytes), filepath.Base(d.Path), d.Category)
+		}
+	}
+	fmt.Printf("\nReclaimable: %s in %d directories\n", humanReadableBytes(total), len(dirs))
+	return 0
+}
@@ -1,17 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io/fs" // For file system abstraction, especially fs.FileInfo
 	"os"    // For command-line arguments, file operations, Stat (file info)
 	"path/filepath" // For walking directory trees
+	"strconv"
+	"strings"
+	"time"
 	// "strconv" // REMOVED: This import is no longer needed as we don't use strconv.Atoi or similar here.
 )
 //new thing here
 
-Some 
-
-
 // humanReadableBytes converts bytes to a human-readable string (KB, MB, GB, etc.)
 func humanReadableBytes(bytes int64) string {
 	const (
@@ -35,8 +38,292 @@ func humanReadableBytes(bytes int64) string {
 	}
 }
 
+// commands maps a subcommand name (the first command-line argument) to the
+// function that runs it. Each function receives the remaining arguments and
+// returns the exit code for the process.
+var commands = map[string]func(args []string) int{
+	"watch":    runWatch,
+	"estimate": runEstimate,
+	"dupes":    runDupes,
+	"dupdirs":  runDupDirs,
+	"manifest": runManifest,
+	"types":    runTypes,
+	"age":      runAge,
+	"explain":  runExplain,
+	"procs":    runProcs,
+	"junk":     runJunk,
+	"git":      runGit,
+	"guard":    runGuard,
+}
+
+// parseByteSize parses sizes such as "512", "64K", "1.5G" or "2TB" into bytes,
+// using the same 1024-based units as humanReadableBytes.
+func parseByteSize(s string) (int64, error) {
+	units := map[string]float64{
+		"": 1, "B": 1,
+		"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+		"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+		"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+		"T": 1 << 40, "TB": 1 << 40, "TIB": 1 << 40,
+	}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	i := strings.IndexFunc(upper, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(upper)
+	}
+	value, err := strconv.ParseFloat(upper[:i], 64)
+	unit, ok := units[strings.TrimSpace(upper[i:])]
+	if err != nil || !ok || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * unit), nil
+}
+
 func main() {
-	fmt.Println("Hello, World!")
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+	os.Exit(runScan(os.Args[1:]))
+}
+
+// runScan implements the default command: `dirsize [flags] [DIR]`.
+func runScan(args []string) int {
+	flags := flag.NewFlagSet("dirsize", flag.ExitOnError)
+	checkpoint := flags.String("checkpoint", "", "periodically save scan progress to this state file")
+	interval := flags.Duration("checkpoint-interval", time.Minute, "how often to save progress when checkpointing")
+	resume := flags.String("resume", "", "continue the interrupted scan saved in this state file")
+	workers := flags.Int("workers", 0, "number of directories read in parallel (0 = one per CPU)")
+	generic := flags.Bool("generic", false, "use the portable directory reader instead of the Linux getdents64/statx one")
+	top := flags.Int("top", 0, "also list the N largest directories")
+	adaptive := flags.Bool("adaptive", false, "tune the number of parallel reads to the storage latency")
+	minWorkers := flags.Int("min-workers", 1, "lower bound for -adaptive")
+	maxWorkers := flags.Int("max-workers", 64, "upper bound for -adaptive")
+	verbose := flags.Bool("verbose", false, "report walker decisions on stderr")
+	maxOps := flags.Int("max-ops", 0, "cap metadata operations per second (0 = unlimited)")
+	maxDirs := flags.Int("max-dirs", 0, "cap directories read per second (0 = unlimited)")
+	idle := flags.Bool("idle", false, "run with idle I/O priority and the lowest CPU priority")
+	noatime := flags.Bool("noatime", false, "don't update access times of the directories read (where permitted)")
+	progress := flags.String("progress", "auto", "show live progress on stderr: auto (when stderr is a terminal), always or never")
+	memoryBudget := flags.String("memory-budget", "", "keep per-directory totals within this much memory (e.g. 256M), spilling to disk")
+	report := flags.String("report", "", "with -memory-budget, write every directory's total to this file")
+	timeout := flags.Duration("timeout", 0, "abandon directories where a single readdir or stat takes longer than this (0 = wait forever)")
+	owners := flags.Bool("owners", false, "also report usage by user and group")
+	ownersByDir := flags.Bool("owners-by-dir", false, "with -owners, also break usage down per top-level directory")
+	sortBy := flags.String("sort", "size", "with -top, order directories by size, newest or oldest (least recently changed first)")
+	changedWithin := flags.Duration("changed-within", 0, "with -top, only list directories with a file modified within this long")
+	unchangedFor := flags.Duration("unchanged-for", 0, "with -top, only list directories with no file modified for this long")
+	freshness := flags.Bool("freshness", false, "with -top, show the newest and oldest file times of each directory")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	histogram := flags.Bool("histogram", false, "also show how file sizes are distributed, in power-of-two buckets")
+	inodes := flags.Int("inodes", 0, "also count entries by type and list the N directories with the most entries")
+	flags.Parse(args)
+
+	dirPath := "."
+	if flags.NArg() > 0 {
+		dirPath = flags.Arg(0)
+	}
+	if *resume != "" || *checkpoint != "" {
+		// The resumable scan is a sequential walk of its own, so none of the
+		// walker's options or the reports built on it apply.
+		var ignored []string
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "workers", "generic", "adaptive", "min-workers", "max-workers", "max-ops", "max-dirs",
+				"idle", "noatime", "progress", "timeout", "top", "memory-budget", "report",
+				"owners", "owners-by-dir", "histogram", "inodes":
+				ignored = append(ignored, "-"+f.Name)
+			}
+		})
+		if len(ignored) > 0 {
+			fmt.Fprintf(os.Stderr, "%s cannot be combined with -checkpoint or -resume\n", strings.Join(ignored, ", "))
+			return 2
+		}
+		if *interval <= 0 {
+			fmt.Fprintln(os.Stderr, "-checkpoint-interval must be positive")
+			return 2
+		}
+	}
+	if *memoryBudget != "" {
+		// The spilled scan only keeps totals, so it can't order or filter
+		// directories by when their files changed.
+		var ignored []string
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "sort", "changed-within", "unchanged-for", "freshness":
+				ignored = append(ignored, "-"+f.Name)
+			}
+		})
+		if len(ignored) > 0 {
+			fmt.Fprintf(os.Stderr, "%s cannot be combined with -memory-budget\n", strings.Join(ignored, ", "))
+			return 2
+		}
+	}
+
+	opts := walkOptions{
+		Workers:    *workers,
+		Generic:    *generic,
+		Adaptive:   *adaptive,
+		MinWorkers: *minWorkers,
+		MaxWorkers: *maxWorkers,
+
+		OpsPerSecond:  *maxOps,
+		DirsPerSecond: *maxDirs,
+		NoATime:       *noatime,
+		Timeout:       *timeout,
+	}
+	switch *progress {
+	case "auto", "always", "never":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -progress %q: want auto, always or never\n", *progress)
+		return 2
+	}
+	if *progress == "always" || (*progress == "auto" && isTerminal(os.Stderr)) {
+		opts.OnProgress = printProgress
+	}
+	listing := dirListing{Sort: *sortBy, ChangedWithin: *changedWithin, UnchangedFor: *unchangedFor, Now: time.Now()}
+	if err := listing.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	withTimes := *freshness || listing.usesTimes()
+	var ownerReport *ownerCollector
+	if *owners {
+		ownerReport = newOwnerCollector(dirPath, *ownersByDir)
+		opts.StatOwners = true
+		opts.OnEntry = ownerReport.visit
+	}
+	// Further reports chain onto whatever hook is already set.
+	addHook := func(hook func(*walkEntry)) {
+		if prev := opts.OnEntry; prev != nil {
+			opts.OnEntry = func(e *walkEntry) {
+				prev(e)
+				hook(e)
+			}
+		} else {
+			opts.OnEntry = hook
+		}
+	}
+	var sizes *sizeHistogram
+	if *histogram {
+		sizes = &sizeHistogram{}
+		addHook(sizes.visit)
+	}
+	var entries *inodeCollector
+	if *inodes > 0 {
+		entries = newInodeCollector(dirPath)
+		addHook(entries.visit)
+	}
+	var timedOut []string
+	opts.OnTimeout = func(dir string) {
+		timedOut = append(timedOut, dir)
+	}
+	if *idle {
+		if err := lowerPriority(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *verbose {
+		opts.OnConcurrency = func(workers int, latency time.Duration, opsPerSec float64) {
+			fmt.Fprintf(os.Stderr, "concurrency: %d workers (%v per operation, %.0f operations/s)\n", workers, latency.Round(time.Microsecond), opsPerSec)
+		}
+	}
+
+	var size int64
+	var tree *sizeTree
+	var largest []dirTotal
+	var err error
+	switch {
+	case *resume != "":
+		// The state file remembers the root, so DIR is not needed here.
+		var state *scanState
+		if state, err = loadScanState(*resume); err == nil {
+			dirPath = state.Root
+			size, err = calculateDirSizeResumable(state, *resume, *interval)
+		}
+	case *checkpoint != "":
+		var state *scanState
+		if state, err = newScanState(dirPath); err == nil {
+			size, err = calculateDirSizeResumable(state, *checkpoint, *interval)
+		}
+	case *memoryBudget != "":
+		size, largest, err = runSpilledScan(dirPath, opts, *memoryBudget, *top, *report)
+	case *top > 0:
+		treeOpts := opts
+		treeOpts.StatTimes = withTimes
+		if tree, err = buildSizeTree(dirPath, treeOpts); err == nil {
+			size = tree.total(0)
+		}
+	default:
+		size, err = scanDirSize(dirPath, opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var entryReport *inodeReport
+	if entries != nil {
+		entries.finish()
+		r := entries.report(*inodes)
+		entryReport = &r
+	}
+	var dirs []uint32
+	if tree != nil {
+		if dirs, err = listing.dirs(tree, *top); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if *asJSON {
+		out := scanReport{Path: dirPath, Bytes: size, TimedOut: timedOut}
+		if sizes != nil {
+			out.Histogram = sizes.buckets()
+		}
+		out.Entries = entryReport
+		for _, i := range dirs {
+			out.Dirs = append(out.Dirs, dirReportOf(tree, i))
+		}
+		for _, d := range largest {
+			out.Dirs = append(out.Dirs, dirReport{Path: d.Path, Bytes: d.Bytes})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Printf("Total size of %s: %s (%d bytes)\n", dirPath, humanReadableBytes(size), size)
+	if tree != nil {
+		printDirs(tree, dirs, withTimes)
+	}
+	if len(largest) > 0 {
+		fmt.Println()
+		for _, d := range largest {
+			fmt.Printf("%12s  %s\n", humanReadableBytes(d.Bytes), d.Path)
+		}
+	}
+	if ownerReport != nil {
+		ownerReport.print()
+	}
+	if sizes != nil {
+		sizes.print()
+	}
+	if entryReport != nil {
+		entryReport.print()
+	}
+	if len(timedOut) > 0 {
+		fmt.Printf("\n%d directories timed out and are only partially counted:\n", len(timedOut))
+		for _, dir := range timedOut {
+			fmt.Printf("  %s (timed out)\n", dir)
+		}
+	}
+	return 0
 }
 
 // calculateDirSize recursively calculates the total size of files in a directory.
@@ -76,4 +363,67 @@ func calculateDirSize(dirPath string) (int64, error) {
 	}
 
 	return totalSize, nil
-}
\ No newline at end of file
+}
+
+// printDirs lists directories of a scanned tree with their totals and, if
+// asked for, when the newest and oldest files beneath them were modified and
+// which file is the newest.
+func printDirs(tree *sizeTree, dirs []uint32, withTimes bool) {
+	fmt.Println()
+	for _, i := range dirs {
+		if !withTimes {
+			fmt.Printf("%12s  %s\n", humanReadableBytes(tree.total(i)), tree.path(i))
+			continue
+		}
+		newest, oldest, file, ok := tree.freshness(i)
+		if !ok {
+			fmt.Printf("%12s  %16s  %16s  %s (no files)\n", humanReadableBytes(tree.total(i)), "-", "-", tree.path(i))
+			continue
+		}
+		fmt.Printf("%12s  %16s  %16s  %s (newest: %s)\n", humanReadableBytes(tree.total(i)),
+			newest.Format("2006-01-02 15:04"), oldest.Format("2006-01-02 15:04"), tree.path(i), tree.path(file))
+	}
+}
+
+// runSpilledScan is the memory-bounded variant of the default scan. The
+// per-directory report is written as it is produced, as tab-separated bytes,
+// files and path, so it never has to fit in memory either.
+func runSpilledScan(dirPath string, opts walkOptions, budget string, top int, reportPath string) (int64, []dirTotal, error) {
+	limit, err := parseByteSize(budget)
+	if err != nil {
+		return 0, nil, err
+	}
+	if limit < minSpillBudget {
+		return 0, nil, fmt.Errorf("-memory-budget must be at least %s", humanReadableBytes(minSpillBudget))
+	}
+
+	var report func(dirTotal)
+	var f *os.File
+	var w *bufio.Writer
+	if reportPath != "" {
+		if f, err = os.Create(reportPath); err != nil {
+			return 0, nil, err
+		}
+		defer f.Close()
+		// The writer keeps the first error, so checking Flush at the end is
+		// enough to catch a short write anywhere in the report.
+		w = bufio.NewWriter(f)
+		report = func(d dirTotal) {
+			fmt.Fprintf(w, "%d\t%d\t%s\n", d.Bytes, d.Files, d.Path)
+		}
+	}
+
+	total, largest, err := scanSpilled(dirPath, opts, limit, top, report)
+	if err != nil {
+		return 0, nil, err
+	}
+	if w != nil {
+		if err := w.Flush(); err != nil {
+			return 0, nil, fmt.Errorf("failed to write %s: %w", reportPath, err)
+		}
+		if err := f.Close(); err != nil {
+			return 0, nil, fmt.Errorf("failed to write %s: %w", reportPath, err)
+		}
+	}
+	return total.Bytes, largest, nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// manifestEntry is one line of a manifest: a regular file, by its path
+// relative to the manifest's root.
+type manifestEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// manifestFiles walks root and returns its regular files as dupeFiles keyed
+// by their path relative to root, leaving out the manifest itself (skip) if
+// it is inside the tree. Unreadable entries are reported the same way as in
+// a size scan.
+func manifestFiles(root string, opts walkOptions, skip string) (map[string]dupeFile, error) {
+	files := make(map[string]dupeFile)
+	opts.StatTimes = true
+	err := walkTree(root, opts, func(e *walkEntry) {
+		if !e.Mode.IsRegular() {
+			return
+		}
+		path := e.Path()
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == skip {
+			return
+		}
+		files[filepath.ToSlash(rel)] = dupeFile{Path: path, Size: e.Size, ModTime: e.ModTime.UnixNano()}
+	})
+	return files, err
+}
+
+// createManifest hashes every regular file under root and writes the
+// manifest to w in path order, one JSON object per line.
+func createManifest(root string, opts walkOptions, workers int, skip string, w io.Writer) (int, int64, error) {
+	files, err := manifestFiles(root, opts, skip)
+	if err != nil {
+		return 0, 0, err
+	}
+	list := make([]dupeFile, 0, len(files))
+	for _, f := range files {
+		list = append(list, f)
+	}
+	hashes := hashAll(list, false, workers, &hashCache{})
+
+	rels := make([]string, 0, len(files))
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	var count int
+	var bytes int64
+	for _, rel := range rels {
+		f := files[rel]
+		hash, ok := hashes[f.Path]
+		if !ok {
+			continue // unreadable, already reported
+		}
+		err := enc.Encode(manifestEntry{Path: rel, Size: f.Size, ModTime: time.Unix(0, f.ModTime).UTC(), SHA256: hash})
+		if err != nil {
+			return count, bytes, fmt.Errorf("failed to write manifest: %w", err)
+		}
+		count++
+		bytes += f.Size
+	}
+	if err := bw.Flush(); err != nil {
+		return count, bytes, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return count, bytes, nil
+}
+
+// loadManifest reads a manifest written by createManifest.
+func loadManifest(path string) (map[string]manifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]manifestEntry)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e manifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid manifest line %d: %w", line, err)
+		}
+		entries[e.Path] = e
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return entries, nil
+}
+
+// manifestReport is the outcome of verifying a tree against a manifest.
+// Modified files have a different size or modification time, as after an
+// ordinary edit. Corrupted files kept their size and modification time but
+// not their content, which is what bit rot looks like.
+type manifestReport struct {
+	Missing       []string `json:"missing"`
+	Extra         []string `json:"extra"`
+	Modified      []string `json:"modified"`
+	Corrupted     []string `json:"corrupted"`
+	Unreadable    []string `json:"unreadable"`
+	Verified      int      `json:"verified"`
+	VerifiedBytes int64    `json:"verified_bytes"`
+}
+
+// ok reports whether the tree matches the manifest exactly.
+func (r *manifestReport) ok() bool {
+	return len(r.Missing)+len(r.Extra)+len(r.Modified)+len(r.Corrupted)+len(r.Unreadable) == 0
+}
+
+// verifyManifest compares the tree under root with the manifest. Only files
+// whose size and modification time still match are hashed.
+func verifyManifest(root string, manifest map[string]manifestEntry, opts walkOptions, workers int, skip string) (manifestReport, error) {
+	var r manifestReport
+	files, err := manifestFiles(root, opts, skip)
+	if err != nil {
+		return r, err
+	}
+
+	var check []dupeFile
+	for rel, f := range files {
+		want, ok := manifest[rel]
+		switch {
+		case !ok:
+			r.Extra = append(r.Extra, rel)
+		case want.Size != f.Size || want.ModTime.UnixNano() != f.ModTime:
+			r.Modified = append(r.Modified, rel)
+		default:
+			check = append(check, f)
+		}
+	}
+	for rel := range manifest {
+		if _, ok := files[rel]; !ok {
+			r.Missing = append(r.Missing, rel)
+		}
+	}
+
+	hashes := hashAll(check, false, workers, &hashCache{})
+	for _, f := range check {
+		rel, _ := filepath.Rel(root, f.Path)
+		rel = filepath.ToSlash(rel)
+		hash, ok := hashes[f.Path]
+		switch {
+		case !ok:
+			r.Unreadable = append(r.Unreadable, rel)
+		case hash != manifest[rel].SHA256:
+			r.Corrupted = append(r.Corrupted, rel)
+		default:
+			r.Verified++
+			r.VerifiedBytes += f.Size
+		}
+	}
+
+	for _, list := range [][]string{r.Missing, r.Extra, r.Modified, r.Corrupted, r.Unreadable} {
+		sort.Strings(list)
+	}
+	return r, nil
+}
+
+// runManifest implements `dirsize manifest create|verify [flags] [DIR]`.
+func runManifest(args []string) int {
+	if len(args) == 0 || (args[0] != "create" && args[0] != "verify") {
+		fmt.Fprintln(os.Stderr, "usage: dirsize manifest create|verify [flags] [DIR]")
+		return 2
+	}
+	action := args[0]
+
+	flags := flag.NewFlagSet("manifest "+action, flag.ExitOnError)
+	manifestPath := flags.String("f", "MANIFEST.jsonl", "manifest file to write or check against")
+	workers := flags.Int("workers", runtime.NumCPU(), "files hashed in parallel")
+	noatime := flags.Bool("noatime", false, "don't update access times of the files and directories read (where permitted)")
+	timeout := flags.Duration("timeout", 0, "give up on directories where a single operation takes longer than this")
+	asJSON := flags.Bool("json", false, "with verify, print the report as JSON")
+	flags.Parse(args[1:])
+
+	if *workers < 1 {
+		fmt.Fprintln(os.Stderr, "dirsize manifest: -workers must be at least 1")
+		return 2
+	}
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	root = filepath.Clean(root)
+	opts := walkOptions{NoATime: *noatime, Timeout: *timeout}
+	if *timeout > 0 {
+		opts.OnTimeout = func(dir string) {
+			fmt.Printf("Error accessing %s: timed out\n", dir)
+		}
+	}
+
+	// The manifest usually lives inside the tree it describes. Either path
+	// may be relative, so both are made absolute before comparing.
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	absManifest, err := filepath.Abs(*manifestPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	skip, err := filepath.Rel(absRoot, absManifest)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if action == "create" {
+		f, err := os.Create(*manifestPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		count, bytes, err := createManifest(root, opts, *workers, skip, f)
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("failed to write manifest: %w", cerr)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Wrote %s: %d files, %s\n", *manifestPath, count, humanReadableBytes(bytes))
+		return 0
+	}
+
+	manifest, err := loadManifest(*manifestPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	report, err := verifyManifest(root, manifest, opts, *workers, skip)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		for _, group := range []struct {
+			label string
+			paths []string
+		}{
+			{"Corrupted", report.Corrupted},
+			{"Missing", report.Missing},
+			{"Modified", report.Modified},
+			{"Extra", report.Extra},
+			{"Unreadable", report.Unreadable},
+		} {
+			for _, path := range group.paths {
+				fmt.Printf("%-10s %s\n", group.label, path)
+			}
+		}
+		fmt.Printf("\nVerified %d files (%s); %d corrupted, %d missing, %d modified, %d extra, %d unreadable\n",
+			report.Verified, humanReadableBytes(report.VerifiedBytes), len(report.Corrupted),
+			len(report.Missing), len(report.Modified), len(report.Extra), len(report.Unreadable))
+	}
+	if !report.ok() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxOrphanExamples is how many paths are kept per orphaned UID to show
+// where its files are.
+const maxOrphanExamples = 5
+
+// ownerUsage is what one user or group owns.
+type ownerUsage struct {
+	Bytes, Files int64
+}
+
+// ownerTotals aggregates usage by UID and by GID.
+type ownerTotals struct {
+	users  map[uint32]*ownerUsage
+	groups map[uint32]*ownerUsage
+	bytes  int64
+}
+
+func newOwnerTotals() *ownerTotals {
+	return &ownerTotals{users: make(map[uint32]*ownerUsage), groups: make(map[uint32]*ownerUsage)}
+}
+
+func (t *ownerTotals) add(uid, gid uint32, size int64) {
+	u, ok := t.users[uid]
+	if !ok {
+		u = &ownerUsage{}
+		t.users[uid] = u
+	}
+	u.Bytes += size
+	u.Files++
+	g, ok := t.groups[gid]
+	if !ok {
+		g = &ownerUsage{}
+		t.groups[gid] = g
+	}
+	g.Bytes += size
+	g.Files++
+	t.bytes += size
+}
+
+// loadIDNames reads the names of the numeric IDs in a passwd or group file,
+// where the name is the first field and the ID the third. It returns nil if
+// the file cannot be read, as on systems without one.
+func loadIDNames(path string) map[uint32]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	names := make(map[uint32]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		if _, dup := names[uint32(id)]; !dup {
+			names[uint32(id)] = fields[0]
+		}
+	}
+	return names
+}
+
+// ownerCollector counts files by owner as a walkOptions.OnEntry hook, for
+// the whole tree and, optionally, per top-level directory. Files owned by a
+// UID missing from the passwd file are remembered as orphaned.
+type ownerCollector struct {
+	root    string
+	byDir   bool
+	total   *ownerTotals
+	dirs    map[string]*ownerTotals
+	users   map[uint32]string // nil if there is no passwd file
+	groups  map[uint32]string
+	orphans map[uint32][]string // example paths per orphaned UID
+
+	// Entries arrive in batches per directory, so the top-level directory
+	// of the last one is remembered, as in scanSpilled.
+	lastDir string
+	lastTop *ownerTotals
+}
+
+func newOwnerCollector(root string, byDir bool) *ownerCollector {
+	return &ownerCollector{
+		root:    filepath.Clean(root),
+		byDir:   byDir,
+		total:   newOwnerTotals(),
+		dirs:    make(map[string]*ownerTotals),
+		users:   loadIDNames("/etc/passwd"),
+		groups:  loadIDNames("/etc/group"),
+		orphans: make(map[uint32][]string),
+	}
+}
+
+// topLevel returns the totals of the top-level directory dir lies in, or
+// nil for the root itself.
+func (c *ownerCollector) topLevel(dir string) *ownerTotals {
+	if dir == c.lastDir {
+		return c.lastTop
+	}
+	rel, err := filepath.Rel(c.root, dir)
+	var top *ownerTotals
+	if err == nil && rel != "." {
+		first, _, _ := strings.Cut(rel, string(filepath.Separator))
+		path := filepath.Join(c.root, first)
+		if top = c.dirs[path]; top == nil {
+			top = newOwnerTotals()
+			c.dirs[path] = top
+		}
+	}
+	c.lastDir, c.lastTop = dir, top
+	return top
+}
+
+func (c *ownerCollector) visit(e *walkEntry) {
+	if e.Mode.IsDir() {
+		return
+	}
+	c.total.add(e.Uid, e.Gid, e.Size)
+	if c.byDir && e.Depth > 1 {
+		c.topLevel(e.Dir).add(e.Uid, e.Gid, e.Size)
+	}
+	if c.users != nil {
+		if _, ok := c.users[e.Uid]; !ok && len(c.orphans[e.Uid]) < maxOrphanExamples {
+			c.orphans[e.Uid] = append(c.orphans[e.Uid], e.Path())
+		}
+	}
+}
+
+// idName returns the name of id, or the number itself if it has none.
+func idName(names map[uint32]string, id uint32) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// printOwnerTable prints usage largest first, with each owner's share.
+func printOwnerTable(title string, usage map[uint32]*ownerUsage, names map[uint32]string, orphaned func(uint32) bool, total int64, indent string) {
+	ids := make([]uint32, 0, len(usage))
+	for id := range usage {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if usage[ids[i]].Bytes != usage[ids[j]].Bytes {
+			return usage[ids[i]].Bytes > usage[ids[j]].Bytes
+		}
+		return ids[i] < ids[j]
+	})
+
+	fmt.Printf("%s%s:\n", indent, title)
+	for _, id := range ids {
+		u := usage[id]
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(u.Bytes) / float64(total)
+		}
+		note := ""
+		if orphaned != nil && orphaned(id) {
+			note = "  (orphaned)"
+		}
+		fmt.Printf("%s  %12s %5.1f%% %10d files  %s (%d)%s\n", indent, humanReadableBytes(u.Bytes), share,
+			u.Files, idName(names, id), id, note)
+	}
+}
+
+// print writes the report gathered by the collector.
+func (c *ownerCollector) print() {
+	orphaned := func(uid uint32) bool {
+		_, ok := c.orphans[uid]
+		return ok
+	}
+	fmt.Println()
+	printOwnerTable("Usage by user", c.total.users, c.users, orphaned, c.total.bytes, "")
+	printOwnerTable("Usage by group", c.total.groups, c.groups, nil, c.total.bytes, "")
+
+	if c.byDir {
+		paths := make([]string, 0, len(c.dirs))
+		for path := range c.dirs {
+			paths = append(paths, path)
+		}
+		sort.Slice(paths, func(i, j int) bool { return c.dirs[paths[i]].bytes > c.dirs[paths[j]].bytes })
+		for _, path := range paths {
+			t := c.dirs[path]
+			fmt.Printf("\n%s: %s\n", path, humanReadableBytes(t.bytes))
+			printOwnerTable("By user", t.users, c.users, orphaned, t.bytes, "  ")
+		}
+	}
+
+	if len(c.orphans) > 0 {
+		uids := make([]uint32, 0, len(c.orphans))
+		for uid := range c.orphans {
+			uids = append(uids, uid)
+		}
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+		fmt.Printf("\nFiles owned by UIDs that no longer exist:\n")
+		for _, uid := range uids {
+			u := c.total.users[uid]
+			fmt.Printf("  UID %d: %s in %d files, e.g.\n", uid, humanReadableBytes(u.Bytes), u.Files)
+			for _, path := range c.orphans[uid] {
+				fmt.Printf("    %s\n", path)
+			}
+		}
+	}
+}
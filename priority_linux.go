@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Constants for ioprio_set(2) from <linux/ioprio.h>.
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// noatimeFlag is added to open flags when the scan should leave access times
+// alone.
+const noatimeFlag = syscall.O_NOATIME
+
+// lowerPriority puts the scan into the idle I/O scheduling class and drops
+// its CPU niceness to 19, so it only gets disk and CPU time nobody else
+// wants. Both are per-thread attributes on Linux, so every existing thread of
+// the process is changed; threads the Go runtime starts later inherit them.
+func lowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("failed to list threads: %w", err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+		if errno != 0 && errno != syscall.ESRCH {
+			return fmt.Errorf("failed to set idle I/O priority: %w", errno)
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, 19); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to lower CPU priority: %w", err)
+		}
+	}
+	return nil
+}
+
+// openNoATime opens path for reading without updating its access time.
+// O_NOATIME is only permitted for the file's owner (or with CAP_FOWNER), so
+// on EPERM it falls back to a normal open.
+func openNoATime(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|noatimeFlag, 0)
+	if errors.Is(err, syscall.EPERM) {
+		return os.Open(path)
+	}
+	return f, err
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// noatimeFlag is zero where O_NOATIME does not exist.
+const noatimeFlag = 0
+
+// lowerPriority is only implemented on Linux.
+func lowerPriority() error {
+	return errors.New("idle I/O priority is only supported on Linux")
+}
+
+// openNoATime is a plain open where O_NOATIME does not exist.
+func openNoATime(path string) (*os.File, error) {
+	return os.Open(path)
+}
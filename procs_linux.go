@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+// heldFile is an open file in the procs report.
+type heldFile struct {
+	openFile
+	Mode string
+}
+
+// filesHeldUnder returns the files open below dir that are either deleted,
+// and so kept alive only by the process holding them, or open for writing.
+// With all, files open only for reading are included too.
+func filesHeldUnder(dir string, all bool) ([]heldFile, int, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	files, denied, err := listOpenFiles()
+	if err != nil {
+		return nil, 0, err
+	}
+	var held []heldFile
+	for _, f := range files {
+		if !isWithinDir(f.Path, dir) {
+			continue
+		}
+		flags, err := fdFlags(f.PID, f.FD)
+		if err != nil {
+			continue // closed in the meantime
+		}
+		writing := flags&syscall.O_ACCMODE != syscall.O_RDONLY
+		if all || f.Deleted || writing {
+			held = append(held, heldFile{f, openMode(flags)})
+		}
+	}
+	sort.Slice(held, func(i, j int) bool {
+		if held[i].Size != held[j].Size {
+			return held[i].Size > held[j].Size
+		}
+		if held[i].PID != held[j].PID {
+			return held[i].PID < held[j].PID
+		}
+		return held[i].FD < held[j].FD
+	})
+	return held, denied, nil
+}
+
+// runProcs implements `dirsize procs [flags] [PATH]`.
+func runProcs(args []string) int {
+	flags := flag.NewFlagSet("procs", flag.ExitOnError)
+	all := flags.Bool("all", false, "also list files that are only open for reading")
+	flags.Parse(args)
+
+	dir := "/"
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+	held, denied, err := filesHeldUnder(dir, *all)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Several processes can share one deleted file, e.g. after a fork, but
+	// its blocks are only held once.
+	type inodeKey struct{ dev, ino uint64 }
+	var deletedBytes int64
+	deletedBy := make(map[int]bool)
+	counted := make(map[inodeKey]bool)
+	fmt.Printf("%8s  %-16s %4s %-4s %12s  %s\n", "PID", "COMMAND", "FD", "MODE", "SIZE", "FILE")
+	for _, f := range held {
+		name := f.Path
+		if f.Deleted {
+			name += " (deleted)"
+			if key := (inodeKey{f.Dev, f.Ino}); !counted[key] {
+				counted[key] = true
+				deletedBytes += f.Blocks * 512
+			}
+			deletedBy[f.PID] = true
+		}
+		fmt.Printf("%8d  %-16s %4d %-4s %12s  %s\n", f.PID, f.Command, f.FD, f.Mode, humanReadableBytes(f.Size), name)
+	}
+	fmt.Printf("\n%d open files; deleted files held open by %d processes still use %s\n",
+		len(held), len(deletedBy), humanReadableBytes(deletedBytes))
+	if denied > 0 {
+		fmt.Printf("%d processes could not be inspected; run as root to see their open files.\n", denied)
+	}
+	return 0
+}
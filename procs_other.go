@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runProcs reads /proc/<pid>/fd and fdinfo, so it is only implemented on
+// Linux.
+func runProcs(args []string) int {
+	fmt.Fprintln(os.Stderr, "dirsize procs is only supported on Linux")
+	return 1
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// defaultProgressInterval is how often progress is reported when
+// walkOptions.ProgressInterval is not set.
+const defaultProgressInterval = 250 * time.Millisecond
+
+// scanProgress is a snapshot of a running walk, handed to
+// walkOptions.OnProgress a few times a second and once more when the walk
+// has finished.
+type scanProgress struct {
+	Files       int64  // entries other than directories seen so far
+	Dirs        int64  // directories seen so far
+	Bytes       int64  // sum of the sizes of the files seen so far
+	Errors      int64  // entries that could not be read
+	CurrentPath string // a directory being read right now
+	Elapsed     time.Duration
+	Done        bool
+}
+
+// FilesPerSecond is the average rate of the walk so far.
+func (p scanProgress) FilesPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Files) / p.Elapsed.Seconds()
+}
+
+// progressCounters is updated by the walker on its hot path, so it only uses
+// atomics; snapshots are taken from a separate goroutine on a ticker.
+type progressCounters struct {
+	files, dirs, bytes, errors atomic.Int64
+	current                    atomic.Pointer[string]
+	start                      time.Time
+}
+
+func (c *progressCounters) count(e *walkEntry) {
+	if e.Mode.IsDir() {
+		c.dirs.Add(1)
+		return
+	}
+	c.files.Add(1)
+	c.bytes.Add(e.Size)
+}
+
+func (c *progressCounters) snapshot(done bool) scanProgress {
+	p := scanProgress{
+		Files:   c.files.Load(),
+		Dirs:    c.dirs.Load(),
+		Bytes:   c.bytes.Load(),
+		Errors:  c.errors.Load(),
+		Elapsed: time.Since(c.start),
+		Done:    done,
+	}
+	if path := c.current.Load(); path != nil {
+		p.CurrentPath = *path
+	}
+	return p
+}
+
+// report calls fn with a snapshot every interval until stop is closed.
+func (c *progressCounters) report(fn func(scanProgress), interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			fn(c.snapshot(false))
+		}
+	}
+}
+
+// isTerminal reports whether f is connected to a terminal rather than a file
+// or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressPathWidth is how much of the current path fits on the progress
+// line next to the counters.
+const progressPathWidth = 50
+
+// printProgress renders a snapshot as a single, continuously overwritten
+// status line on stderr.
+func printProgress(p scanProgress) {
+	path := p.CurrentPath
+	if len(path) > progressPathWidth {
+		path = "..." + path[len(path)-progressPathWidth+3:]
+	}
+	// \r returns to the start of the line and \033[K clears what was left
+	// over from a longer previous line.
+	fmt.Fprintf(os.Stderr, "\r\033[K%d files, %d dirs, %s, %.0f files/s, %d errors  %s",
+		p.Files, p.Dirs, humanReadableBytes(p.Bytes), p.FilesPerSecond(), p.Errors, path)
+	if p.Done {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// spillEntryOverhead approximates what one directory costs in the in-memory
+// map on top of its key: the map slot, the string header and the counters.
+const spillEntryOverhead = 64
+
+// maxMergeRuns caps how many run files are open at once. With more runs
+// than that, merge first combines them in groups into fewer, larger runs.
+const maxMergeRuns = 64
+
+// minSpillBudget is the smallest memory budget accepted. Below it nearly
+// every directory would get a run file of its own.
+const minSpillBudget = 1 << 20
+
+// dirCounts holds the bytes and files directly inside one directory.
+type dirCounts struct {
+	bytes, files int64
+}
+
+// spillAggregator collects per-directory totals within a memory budget. When
+// the budget is exceeded the current totals are written to a sorted run file
+// and memory is freed; merge later combines all runs in a single streaming
+// pass, like an external merge sort.
+//
+// Directories are keyed by their path relative to the root with separators
+// replaced by NUL bytes. NUL cannot appear in file names and sorts before
+// every other byte, so in key order every directory is immediately followed
+// by its whole subtree, which is what rolling totals up with a stack needs.
+type spillAggregator struct {
+	budget int64
+	used   int64
+	dirs   map[string]*dirCounts
+	tmpDir string
+	runs   []string
+	nruns  int // run files created, for naming them
+}
+
+func newSpillAggregator(budget int64) (*spillAggregator, error) {
+	tmpDir, err := os.MkdirTemp("", "dirsize-spill-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	return &spillAggregator{budget: budget, dirs: make(map[string]*dirCounts), tmpDir: tmpDir}, nil
+}
+
+// close removes the run files.
+func (a *spillAggregator) close() {
+	os.RemoveAll(a.tmpDir)
+}
+
+// spillKey turns a path relative to the root into a sort key.
+func spillKey(rel string) string {
+	if rel == "." {
+		return ""
+	}
+	return strings.ReplaceAll(rel, string(filepath.Separator), "\x00")
+}
+
+// add counts bytes and files directly inside the directory with key key.
+func (a *spillAggregator) add(key string, bytes, files int64) error {
+	c, ok := a.dirs[key]
+	if !ok {
+		c = &dirCounts{}
+		a.dirs[key] = c
+		a.used += int64(len(key)) + spillEntryOverhead
+	}
+	c.bytes += bytes
+	c.files += files
+	if a.used > a.budget {
+		return a.spill()
+	}
+	return nil
+}
+
+// runWriter writes records to a new run file. Records must be written in
+// key order.
+type runWriter struct {
+	f   *os.File
+	w   *bufio.Writer
+	buf [3 * binary.MaxVarintLen64]byte
+}
+
+// newRun creates the next run file.
+func (a *spillAggregator) newRun() (*runWriter, error) {
+	name := filepath.Join(a.tmpDir, fmt.Sprintf("run-%06d", a.nruns))
+	a.nruns++
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+	return &runWriter{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (rw *runWriter) write(key string, c dirCounts) {
+	n := binary.PutUvarint(rw.buf[:], uint64(len(key)))
+	n += binary.PutVarint(rw.buf[n:], c.bytes)
+	n += binary.PutVarint(rw.buf[n:], c.files)
+	rw.w.Write(rw.buf[:n])
+	rw.w.WriteString(key)
+}
+
+// close flushes the run file and returns its name.
+func (rw *runWriter) close() (string, error) {
+	if err := rw.w.Flush(); err != nil {
+		rw.f.Close()
+		return "", fmt.Errorf("failed to write spill file: %w", err)
+	}
+	if err := rw.f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write spill file: %w", err)
+	}
+	return rw.f.Name(), nil
+}
+
+// spill writes the in-memory totals to a new run file in key order.
+func (a *spillAggregator) spill() error {
+	keys := make([]string, 0, len(a.dirs))
+	for key := range a.dirs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rw, err := a.newRun()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		rw.write(key, *a.dirs[key])
+	}
+	name, err := rw.close()
+	if err != nil {
+		return err
+	}
+
+	a.runs = append(a.runs, name)
+	a.dirs = make(map[string]*dirCounts)
+	a.used = 0
+	return nil
+}
+
+// runReader reads one run file back record by record.
+type runReader struct {
+	f     *os.File
+	r     *bufio.Reader
+	key   string
+	count dirCounts
+}
+
+// next advances to the following record and reports whether there was one.
+func (rr *runReader) next() (bool, error) {
+	keyLen, err := binary.ReadUvarint(rr.r)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if rr.count.bytes, err = binary.ReadVarint(rr.r); err != nil {
+		return false, err
+	}
+	if rr.count.files, err = binary.ReadVarint(rr.r); err != nil {
+		return false, err
+	}
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(rr.r, key); err != nil {
+		return false, err
+	}
+	rr.key = string(key)
+	return true, nil
+}
+
+// runHeap orders run readers by their current key for the k-way merge.
+type runHeap []*runReader
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return h[i].key < h[j].key }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	rr := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return rr
+}
+
+// merge spills what is left in memory and streams the combined totals of all
+// runs to fn in key order, one call per directory. No more than
+// maxMergeRuns files are open at a time: if there are more runs, groups of
+// them are first merged into intermediate runs, as often as needed.
+func (a *spillAggregator) merge(fn func(key string, c dirCounts)) error {
+	if len(a.dirs) > 0 {
+		if err := a.spill(); err != nil {
+			return err
+		}
+	}
+	for len(a.runs) > maxMergeRuns {
+		var next []string
+		for i := 0; i < len(a.runs); i += maxMergeRuns {
+			group := a.runs[i:min(i+maxMergeRuns, len(a.runs))]
+			if len(group) == 1 {
+				next = append(next, group[0])
+				continue
+			}
+			rw, err := a.newRun()
+			if err != nil {
+				return err
+			}
+			if err := mergeRuns(group, rw.write); err != nil {
+				rw.close()
+				return err
+			}
+			name, err := rw.close()
+			if err != nil {
+				return err
+			}
+			for _, old := range group {
+				os.Remove(old)
+			}
+			next = append(next, name)
+		}
+		a.runs = next
+	}
+	return mergeRuns(a.runs, fn)
+}
+
+// mergeRuns streams the combined totals of the named run files to fn in key
+// order, one call per directory.
+func mergeRuns(runs []string, fn func(key string, c dirCounts)) error {
+	h := &runHeap{}
+	for _, name := range runs {
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("failed to open spill file: %w", err)
+		}
+		defer f.Close()
+		rr := &runReader{f: f, r: bufio.NewReader(f)}
+		ok, err := rr.next()
+		if err != nil {
+			return fmt.Errorf("failed to read spill file '%s': %w", name, err)
+		}
+		if ok {
+			*h = append(*h, rr)
+		}
+	}
+	heap.Init(h)
+
+	var current string
+	var sum dirCounts
+	started := false
+	for h.Len() > 0 {
+		rr := (*h)[0]
+		if started && rr.key != current {
+			fn(current, sum)
+			sum = dirCounts{}
+		}
+		current, started = rr.key, true
+		sum.bytes += rr.count.bytes
+		sum.files += rr.count.files
+
+		ok, err := rr.next()
+		if err != nil {
+			return fmt.Errorf("failed to read spill file '%s': %w", rr.f.Name(), err)
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	if started {
+		fn(current, sum)
+	}
+	return nil
+}
+
+// dirTotal is one line of the per-directory report.
+type dirTotal struct {
+	Path         string
+	Bytes, Files int64 // recursive
+}
+
+// rollUp turns the direct per-directory counts coming out of merge into
+// recursive totals. Because keys arrive in subtree order, only the chain of
+// directories from the root to the current one is ever held in memory;
+// everything else is handed to report as soon as its subtree is complete, so
+// directories are reported children first.
+type rollUp struct {
+	root   string
+	stack  []dirTotal // keys on the stack are stored in Path until popped
+	report func(dirTotal)
+}
+
+// isUnder reports whether key is anc itself or lies beneath it.
+func isUnder(key, anc string) bool {
+	return anc == "" || key == anc || strings.HasPrefix(key, anc+"\x00")
+}
+
+func (r *rollUp) pop() {
+	top := r.stack[len(r.stack)-1]
+	r.stack = r.stack[:len(r.stack)-1]
+	if len(r.stack) > 0 {
+		parent := &r.stack[len(r.stack)-1]
+		parent.Bytes += top.Bytes
+		parent.Files += top.Files
+	}
+	top.Path = filepath.Join(r.root, strings.ReplaceAll(top.Path, "\x00", string(filepath.Separator)))
+	r.report(top)
+}
+
+func (r *rollUp) add(key string, c dirCounts) {
+	if len(r.stack) == 0 {
+		r.stack = append(r.stack, dirTotal{Path: ""})
+	}
+	for !isUnder(key, r.stack[len(r.stack)-1].Path) {
+		r.pop()
+	}
+	// Push any ancestors that had no records of their own.
+	for top := r.stack[len(r.stack)-1].Path; top != key; top = r.stack[len(r.stack)-1].Path {
+		next := key
+		start := len(top)
+		if top != "" {
+			start++
+		}
+		if i := strings.IndexByte(key[start:], 0); i >= 0 {
+			next = key[:start+i]
+		}
+		r.stack = append(r.stack, dirTotal{Path: next})
+	}
+	top := &r.stack[len(r.stack)-1]
+	top.Bytes += c.bytes
+	top.Files += c.files
+}
+
+func (r *rollUp) finish() {
+	for len(r.stack) > 0 {
+		r.pop()
+	}
+}
+
+// topDirs keeps the n largest directories seen, as a min-heap on bytes.
+type topDirs []dirTotal
+
+func (h topDirs) Len() int           { return len(h) }
+func (h topDirs) Less(i, j int) bool { return h[i].Bytes < h[j].Bytes }
+func (h topDirs) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *topDirs) Push(x any)        { *h = append(*h, x.(dirTotal)) }
+func (h *topDirs) Pop() any {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
+
+// offer adds d if it is among the n largest seen so far.
+func (h *topDirs) offer(d dirTotal, n int) {
+	if h.Len() < n {
+		heap.Push(h, d)
+	} else if n > 0 && d.Bytes > (*h)[0].Bytes {
+		(*h)[0] = d
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the directories largest first.
+func (h topDirs) sorted() []dirTotal {
+	out := append([]dirTotal(nil), h...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Bytes > out[j].Bytes })
+	return out
+}
+
+// scanSpilled sizes root while holding at most about budget bytes of
+// per-directory totals in memory. Every directory's recursive total is
+// passed to report (children before their parents), and the total of the
+// root and its n largest subdirectories are returned.
+func scanSpilled(root string, opts walkOptions, budget int64, n int, report func(dirTotal)) (dirTotal, []dirTotal, error) {
+	agg, err := newSpillAggregator(budget)
+	if err != nil {
+		return dirTotal{}, nil, err
+	}
+	defer agg.close()
+
+	root = filepath.Clean(root)
+	keyOf := func(path string) string {
+		rel, _ := filepath.Rel(root, path)
+		return spillKey(rel)
+	}
+	// Entries arrive in batches per directory, so remembering the key of the
+	// last directory saves computing it again for each of its files.
+	var lastDir, lastKey string
+	var addErr error
+	err = walkTree(root, opts, func(e *walkEntry) {
+		if addErr != nil {
+			return
+		}
+		if e.Mode.IsDir() {
+			// Record every directory, so empty ones are reported too.
+			addErr = agg.add(keyOf(e.Path()), 0, 0)
+			return
+		}
+		dir := e.Dir
+		if dir == "" {
+			dir = root // the root itself is a file
+		}
+		if dir != lastDir {
+			lastDir, lastKey = dir, keyOf(dir)
+		}
+		addErr = agg.add(lastKey, e.Size, 1)
+	})
+	if err == nil {
+		err = addErr
+	}
+	if err != nil {
+		return dirTotal{}, nil, err
+	}
+
+	var total dirTotal
+	top := &topDirs{}
+	r := &rollUp{root: root, report: func(d dirTotal) {
+		if d.Path == root {
+			total = d
+		} else {
+			top.offer(d, n)
+		}
+		if report != nil {
+			report(d)
+		}
+	}}
+	if err := agg.merge(r.add); err != nil {
+		return dirTotal{}, nil, err
+	}
+	r.finish()
+	return total, top.sorted(), nil
+}
@@ -0,0 +1,11 @@
+//go:build darwin || freebsd || dragonfly
+
+package main
+
+import "syscall"
+
+// statfsBlockSize returns the unit f_blocks and friends are counted in,
+// which the BSDs report as f_bsize.
+func statfsBlockSize(st *syscall.Statfs_t) int64 {
+	return int64(st.Bsize)
+}
@@ -0,0 +1,10 @@
+package main
+
+import "syscall"
+
+// statfsBlockSize returns the unit f_blocks and friends are counted in. On
+// Linux that is the fragment size, which can differ from f_bsize, the
+// preferred I/O size.
+func statfsBlockSize(st *syscall.Statfs_t) int64 {
+	return int64(st.Frsize)
+}
@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package main
+
+import "errors"
+
+// fsStats is what statfs reports for the file system holding a path.
+type fsStats struct {
+	BlockSize   int64
+	Blocks      uint64
+	BlocksFree  uint64
+	BlocksAvail uint64
+	Inodes      uint64
+	InodesFree  uint64
+}
+
+// statFS is not implemented on this platform.
+func statFS(path string) (fsStats, error) {
+	return fsStats{}, errors.New("statfs is not supported on this platform")
+}
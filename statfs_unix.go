@@ -0,0 +1,34 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// fsStats is what statfs reports for the file system holding a path.
+type fsStats struct {
+	BlockSize   int64
+	Blocks      uint64 // total data blocks
+	BlocksFree  uint64 // free blocks, including those reserved for root
+	BlocksAvail uint64 // free blocks available to unprivileged users
+	Inodes      uint64
+	InodesFree  uint64
+}
+
+// statFS returns the statfs figures of the file system holding path.
+func statFS(path string) (fsStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return fsStats{}, fmt.Errorf("failed to statfs '%s': %w", path, err)
+	}
+	return fsStats{
+		BlockSize:   statfsBlockSize(&st),
+		Blocks:      uint64(st.Blocks),
+		BlocksFree:  uint64(st.Bfree),
+		BlocksAvail: uint64(st.Bavail),
+		Inodes:      uint64(st.Files),
+		InodesFree:  uint64(st.Ffree),
+	}, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// minThrottleTick bounds how often the throttle's ticker fires. Rates above
+// 100 per second are served by handing out several tokens per tick rather
+// than by ticking faster.
+const minThrottleTick = 10 * time.Millisecond
+
+// throttle caps the rate of some operation. It generalizes the ticker-based
+// rateLimiter from hj.go: instead of one request per tick, a ticker refills
+// a bucket of tokens and callers take one token per operation. The bucket
+// holds at most one tick's worth, so an idle period does not turn into a
+// burst later on.
+type throttle struct {
+	tokens chan struct{}
+	stop   chan struct{}
+}
+
+// newThrottle starts a throttle allowing perSecond operations per second. It
+// must be stopped with close.
+func newThrottle(perSecond int) *throttle {
+	tick := time.Second / time.Duration(perSecond)
+	if tick < minThrottleTick {
+		tick = minThrottleTick
+	}
+	// perTick is usually fractional, e.g. 2.5 for 250 per second at the
+	// minimum tick; the remainder is carried over so the rate comes out
+	// exact on average.
+	perTick := float64(perSecond) * tick.Seconds()
+	capacity := max(int(math.Ceil(perTick)), 1)
+
+	t := &throttle{tokens: make(chan struct{}, capacity), stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		var owed float64
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+			}
+			owed += perTick
+			n := int(owed)
+			owed -= float64(n)
+		refill:
+			for i := 0; i < n; i++ {
+				select {
+				case t.tokens <- struct{}{}:
+				default:
+					break refill // bucket is full
+				}
+			}
+		}
+	}()
+	return t
+}
+
+// wait blocks until the caller may perform one more operation. A nil throttle
+// never blocks, so callers don't need to check whether throttling is on.
+func (t *throttle) wait() {
+	if t != nil {
+		<-t.tokens
+	}
+}
+
+// close stops the throttle's ticker.
+func (t *throttle) close() {
+	if t != nil {
+		close(t.stop)
+	}
+}
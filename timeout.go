@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minTimeoutCheck bounds how often readDirTimeout checks for progress, so
+// that very short timeouts don't turn into a busy loop.
+const minTimeoutCheck = time.Millisecond
+
+// readDirTimeout reads dir like reader.readDir, but gives up when no single
+// operation (a getdents64 chunk or the stat of one entry) completes within
+// timeout. A stale NFS mount can block a stat forever and nothing can
+// interrupt it, so the read runs in its own goroutine and, like the
+// timeout-via-select in selectExample, the caller just stops waiting for it.
+//
+// It returns the entries read before the deadline and whether the read was
+// abandoned. An abandoned goroutine may still be stuck in the kernel and must
+// be treated as lost, so the caller has to stop using reader afterwards.
+func readDirTimeout(reader dirReader, dir string, depth int, ops *throttle,
+	onError func(string, error), timeout time.Duration) ([]walkEntry, bool) {
+	var (
+		mu        sync.Mutex
+		entries   []walkEntry
+		abandoned bool
+		progress  atomic.Int64 // time of the last completed operation, 0 while throttled
+	)
+	progress.Store(time.Now().UnixNano())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		emit := func(e walkEntry) {
+			if e.Stat {
+				// Time spent waiting for the throttle is not the file
+				// system's fault, so the clock stops meanwhile.
+				progress.Store(0)
+				ops.wait()
+			}
+			progress.Store(time.Now().UnixNano())
+			mu.Lock()
+			if !abandoned {
+				entries = append(entries, e)
+			}
+			mu.Unlock()
+		}
+		reportError := func(path string, err error) {
+			progress.Store(time.Now().UnixNano())
+			mu.Lock()
+			gone := abandoned
+			mu.Unlock()
+			if !gone {
+				onError(path, err)
+			}
+		}
+		reader.readDir(dir, depth, emit, reportError)
+	}()
+
+	check := time.NewTicker(max(timeout/4, minTimeoutCheck))
+	defer check.Stop()
+	for {
+		select {
+		case <-done:
+			return entries, false
+		case now := <-check.C:
+			last := progress.Load()
+			if last != 0 && now.Sub(time.Unix(0, last)) > timeout {
+				mu.Lock()
+				abandoned = true
+				kept := entries
+				mu.Unlock()
+				return kept, true
+			}
+		}
+	}
+}
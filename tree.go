@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"sort"
+	"time"
+	"unsafe"
+)
+
+// treeNode is one entry of a sizeTree. It is kept small on purpose: with tens
+// of millions of entries every byte here costs tens of megabytes.
+type treeNode struct {
+	size    int64  // file size, or the recursive total for directories
+	nameOff uint32 // offset of the name in sizeTree.names
+	parent  uint32 // index of the parent node; the root is its own parent
+	nameLen uint16
+	mode    fs.FileMode // type bits only
+}
+
+// sizeTree holds a complete scanned tree without a string per entry. Names
+// are packed back to back into one arena and every node points at its parent
+// by index, so full paths are only built when something is printed.
+//
+// Parents are always added before their children, which lets finish compute
+// the recursive directory totals in a single backwards pass.
+type sizeTree struct {
+	nodes []treeNode
+	names []byte
+	// times is parallel to nodes and only kept when the tree was built with
+	// walkOptions.StatTimes, so plain size scans don't pay for it.
+	times []nodeTimes
+}
+
+// nodeTimes is the freshness of a node: for a file its own modification
+// time, for a directory the newest and oldest modification time of any file
+// beneath it and which file is the newest. Times are Unix nanoseconds.
+type nodeTimes struct {
+	newest, oldest int64
+	newestFile     uint32
+}
+
+// noFiles marks a directory with no files beneath it.
+var noFiles = nodeTimes{newest: math.MinInt64, oldest: math.MaxInt64}
+
+// errTreeTooLarge is returned when a tree outgrows the 32-bit indices and
+// offsets used to keep nodes small.
+var errTreeTooLarge = errors.New("tree too large for the in-memory index")
+
+// add appends an entry below parent and returns its index.
+func (t *sizeTree) add(parent uint32, name string, size int64, mode fs.FileMode) (uint32, error) {
+	if uint64(len(t.nodes)) >= math.MaxUint32 || uint64(len(t.names)+len(name)) > math.MaxUint32 || len(name) > math.MaxUint16 {
+		return 0, errTreeTooLarge
+	}
+	idx := uint32(len(t.nodes))
+	t.nodes = append(t.nodes, treeNode{
+		size:    size,
+		nameOff: uint32(len(t.names)),
+		parent:  parent,
+		nameLen: uint16(len(name)),
+		mode:    mode.Type(),
+	})
+	t.names = append(t.names, name...)
+	return idx, nil
+}
+
+// addTimes records the modification time of the node just added. Trees with
+// times must call it after every add.
+func (t *sizeTree) addTimes(idx uint32, modTime time.Time) {
+	if t.nodes[idx].mode.IsDir() {
+		t.times = append(t.times, noFiles)
+		return
+	}
+	ns := modTime.UnixNano()
+	t.times = append(t.times, nodeTimes{newest: ns, oldest: ns, newestFile: idx})
+}
+
+// finish rolls file sizes, and times if kept, up into their directories. It
+// must be called once after the last add.
+func (t *sizeTree) finish() {
+	for i := len(t.nodes) - 1; i > 0; i-- {
+		p := t.nodes[i].parent
+		t.nodes[p].size += t.nodes[i].size
+		if t.times != nil {
+			c, pt := &t.times[i], &t.times[p]
+			if c.newest > pt.newest {
+				pt.newest, pt.newestFile = c.newest, c.newestFile
+			}
+			pt.oldest = min(pt.oldest, c.oldest)
+		}
+	}
+}
+
+// freshness returns the newest and oldest file modification times beneath
+// node i and the index of the newest file. ok is false if the tree has no
+// times or there are no files beneath i.
+func (t *sizeTree) freshness(i uint32) (newest, oldest time.Time, newestFile uint32, ok bool) {
+	if t.times == nil || t.times[i] == noFiles {
+		return time.Time{}, time.Time{}, 0, false
+	}
+	nt := t.times[i]
+	return time.Unix(0, nt.newest), time.Unix(0, nt.oldest), nt.newestFile, true
+}
+
+// name returns the base name of node i without copying it out of the arena.
+// The string is only valid as long as the tree is not modified.
+func (t *sizeTree) name(i uint32) string {
+	n := &t.nodes[i]
+	if n.nameLen == 0 {
+		return ""
+	}
+	return unsafe.String(&t.names[n.nameOff], int(n.nameLen))
+}
+
+// path materializes the full path of node i.
+func (t *sizeTree) path(i uint32) string {
+	var parts []string
+	for ; i != 0; i = t.nodes[i].parent {
+		parts = append(parts, t.name(i))
+	}
+	parts = append(parts, t.name(0))
+	for l, r := 0, len(parts)-1; l < r; l, r = l+1, r-1 {
+		parts[l], parts[r] = parts[r], parts[l]
+	}
+	return filepath.Join(parts...)
+}
+
+// total returns the size of node i, recursive for directories.
+func (t *sizeTree) total(i uint32) int64 {
+	return t.nodes[i].size
+}
+
+// largestDirs returns the indices of the n directories with the largest
+// totals, biggest first. The root is left out since it is always the largest.
+func (t *sizeTree) largestDirs(n int) []uint32 {
+	return t.topDirs(n, func(a, b uint32) bool { return t.nodes[a].size > t.nodes[b].size }, nil)
+}
+
+// topDirs returns the first n directories below the root in the order given
+// by less, leaving out those keep rejects (keep may be nil).
+func (t *sizeTree) topDirs(n int, less func(a, b uint32) bool, keep func(uint32) bool) []uint32 {
+	var dirs []uint32
+	for i := 1; i < len(t.nodes); i++ {
+		if t.nodes[i].mode.IsDir() && (keep == nil || keep(uint32(i))) {
+			dirs = append(dirs, uint32(i))
+		}
+	}
+	sort.Slice(dirs, func(a, b int) bool { return less(dirs[a], dirs[b]) })
+	if len(dirs) > n {
+		dirs = dirs[:n]
+	}
+	return dirs
+}
+
+// buildSizeTree walks root and keeps every entry in a sizeTree.
+func buildSizeTree(root string, opts walkOptions) (*sizeTree, error) {
+	t := &sizeTree{}
+	var addErr error
+	err := walkTree(root, opts, func(e *walkEntry) {
+		if addErr != nil {
+			return
+		}
+		parent := uint32(e.ParentRef)
+		size := e.Size
+		if e.Mode.IsDir() {
+			// Directories only count what is inside them, as in
+			// calculateDirSize.
+			size = 0
+		}
+		idx, err := t.add(parent, e.Name, size, e.Mode)
+		if err != nil {
+			addErr = err
+			return
+		}
+		if opts.StatTimes {
+			t.addTimes(idx, e.ModTime)
+		}
+		e.Ref = int(idx)
+	})
+	if err == nil {
+		err = addErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.finish()
+	return t, nil
+}
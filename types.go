@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// sniffLen is how much of a file is read to detect its content type; it is
+// all that http.DetectContentType looks at.
+const sniffLen = 512
+
+// typeStats counts the files of one extension or content type.
+type typeStats struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+// typeBreakdown aggregates a set of files by extension and, if sniffing was
+// asked for, by content type.
+type typeBreakdown struct {
+	Extensions   map[string]*typeStats
+	ContentTypes map[string]*typeStats
+	Bytes, Files int64
+}
+
+func newTypeBreakdown() *typeBreakdown {
+	return &typeBreakdown{Extensions: make(map[string]*typeStats), ContentTypes: make(map[string]*typeStats)}
+}
+
+// countType adds a file to the stats for key in m. key may point into a walker
+// buffer, so it is only copied when a new entry is created.
+func countType(m map[string]*typeStats, key string, size int64) {
+	s, ok := m[key]
+	if !ok {
+		s = &typeStats{}
+		m[strings.Clone(key)] = s
+	}
+	s.Bytes += size
+	s.Files++
+}
+
+// merge adds the counts of other to b.
+func (b *typeBreakdown) merge(other *typeBreakdown) {
+	for _, pair := range [][2]map[string]*typeStats{{b.Extensions, other.Extensions}, {b.ContentTypes, other.ContentTypes}} {
+		for key, s := range pair[1] {
+			if t, ok := pair[0][key]; ok {
+				t.Bytes += s.Bytes
+				t.Files += s.Files
+			} else {
+				pair[0][key] = &typeStats{s.Bytes, s.Files}
+			}
+		}
+	}
+}
+
+// fileExtension returns the lower-cased extension of name, or "(none)".
+func fileExtension(name string) string {
+	ext := filepath.Ext(name)
+	if ext == "" || ext == name {
+		return "(none)" // no dot, or a dot file like .bashrc
+	}
+	return strings.ToLower(ext)
+}
+
+// magicTypes covers formats http.DetectContentType does not know about but
+// which matter when asking where the space went: executables, disk images and
+// the compressors used for logs and backups. Checked in order, before it.
+var magicTypes = []struct {
+	offset int
+	magic  []byte
+	typ    string
+}{
+	{0, []byte("\x7fELF"), "application/x-executable"},
+	{0, []byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{0, []byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{0, []byte("\xca\xfe\xba\xbe"), "application/x-mach-binary"},
+	{0, []byte("MZ"), "application/x-msdownload"},
+	{0, []byte("\x28\xb5\x2f\xfd"), "application/zstd"},
+	{0, []byte("\xfd7zXZ\x00"), "application/x-xz"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{0, []byte("SQLite format 3\x00"), "application/vnd.sqlite3"},
+	{0, []byte("QFI\xfb"), "application/x-qemu-disk"},
+	{0, []byte("\x1aE\xdf\xa3"), "video/x-matroska"},
+	{0, []byte("PAR1"), "application/vnd.apache.parquet"},
+	{257, []byte("ustar"), "application/x-tar"},
+	{32769, []byte("CD001"), "application/x-iso9660-image"},
+}
+
+// sniffContentType detects the content type of path from its first bytes,
+// without parameters such as the charset.
+func sniffContentType(path string) (string, error) {
+	f, err := openNoATime(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	buf = buf[:n]
+	if n == 0 {
+		return "(empty)", nil
+	}
+
+	for _, m := range magicTypes {
+		head := buf
+		if m.offset+len(m.magic) > len(buf) {
+			// Only a few formats keep their magic past the sniffed prefix.
+			head = make([]byte, len(m.magic))
+			if _, err := f.ReadAt(head, int64(m.offset)); err != nil {
+				continue
+			}
+		} else {
+			head = buf[m.offset : m.offset+len(m.magic)]
+		}
+		if bytes.Equal(head, m.magic) {
+			return m.typ, nil
+		}
+	}
+	typ, _, _ := strings.Cut(http.DetectContentType(buf), ";")
+	return typ, nil
+}
+
+// sniffJob is a file whose content type is still to be detected.
+type sniffJob struct {
+	path  string
+	size  int64
+	group int
+}
+
+// typeReport is the result of breakdownByType: the whole tree, and the
+// subtree of each top-level directory. Groups[0] holds the files directly
+// inside the root.
+type typeReport struct {
+	Root   *typeBreakdown
+	Names  []string
+	Groups []*typeBreakdown
+}
+
+// breakdownByType walks root and aggregates its regular files by extension,
+// both for the whole tree and per top-level directory. With sniff, the first
+// bytes of every file are read by a pool of workers to detect content types
+// while the walk goes on.
+func breakdownByType(root string, opts walkOptions, sniff bool, workers int) (*typeReport, error) {
+	r := &typeReport{Root: newTypeBreakdown(), Names: []string{"."}, Groups: []*typeBreakdown{newTypeBreakdown()}}
+
+	// Every worker counts into its own copy of the groups; they are merged
+	// at the end, so the workers never contend for a lock.
+	var jobs chan sniffJob
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var sniffed [][]*typeBreakdown
+	if sniff {
+		jobs = make(chan sniffJob, 1024)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var mine []*typeBreakdown
+				for job := range jobs {
+					for len(mine) <= job.group {
+						mine = append(mine, newTypeBreakdown())
+					}
+					typ, err := sniffContentType(job.path)
+					if err != nil {
+						fmt.Printf("Error accessing %s: %v\n", job.path, err)
+						typ = "(unreadable)"
+					}
+					countType(mine[job.group].ContentTypes, typ, job.size)
+				}
+				mu.Lock()
+				sniffed = append(sniffed, mine)
+				mu.Unlock()
+			}()
+		}
+	}
+
+	if sniff {
+		// Jobs are sent once a directory's entries have been visited, as
+		// waiting for a free worker inside visit would hold up every
+		// reader.
+		opts.OnBatch = func(entries []walkEntry) {
+			for i := range entries {
+				if e := &entries[i]; e.Mode.IsRegular() {
+					jobs <- sniffJob{path: e.Path(), size: e.Size, group: e.ParentRef}
+				}
+			}
+		}
+	}
+	err := walkTree(root, opts, func(e *walkEntry) {
+		if e.Mode.IsDir() {
+			switch e.Depth {
+			case 0:
+			case 1:
+				e.Ref = len(r.Groups)
+				r.Names = append(r.Names, e.Path())
+				r.Groups = append(r.Groups, newTypeBreakdown())
+			default:
+				e.Ref = e.ParentRef
+			}
+			return
+		}
+		if !e.Mode.IsRegular() {
+			return
+		}
+		g := r.Groups[e.ParentRef]
+		countType(g.Extensions, fileExtension(e.Name), e.Size)
+		g.Bytes += e.Size
+		g.Files++
+		if sniff && e.Depth == 0 {
+			// The root itself is a file, so there are no batches.
+			jobs <- sniffJob{path: e.Path(), size: e.Size}
+		}
+	})
+	if sniff {
+		close(jobs)
+		wg.Wait()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mine := range sniffed {
+		for i, b := range mine {
+			r.Groups[i].merge(b)
+		}
+	}
+	for _, g := range r.Groups {
+		r.Root.merge(g)
+		r.Root.Bytes += g.Bytes
+		r.Root.Files += g.Files
+	}
+	return r, nil
+}
+
+// typeRow is one line of a breakdown table.
+type typeRow struct {
+	Type string `json:"type"`
+	typeStats
+}
+
+// sortedTypes returns the n largest entries of m by bytes, plus one "(other)"
+// row summing up the rest. n <= 0 means all of them.
+func sortedTypes(m map[string]*typeStats, n int) []typeRow {
+	rows := make([]typeRow, 0, len(m))
+	for key, s := range m {
+		rows = append(rows, typeRow{key, *s})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Bytes != rows[j].Bytes {
+			return rows[i].Bytes > rows[j].Bytes
+		}
+		return rows[i].Type < rows[j].Type
+	})
+	if n > 0 && len(rows) > n {
+		other := typeRow{Type: "(other)"}
+		for _, row := range rows[n:] {
+			other.Bytes += row.Bytes
+			other.Files += row.Files
+		}
+		rows = append(rows[:n], other)
+	}
+	return rows
+}
+
+// printTypeTable prints the rows with each one's share of total.
+func printTypeTable(title string, rows []typeRow, total int64, indent string) {
+	fmt.Printf("%s%s:\n", indent, title)
+	for _, row := range rows {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(row.Bytes) / float64(total)
+		}
+		fmt.Printf("%s  %12s %5.1f%% %10d files  %s\n", indent, humanReadableBytes(row.Bytes), share, row.Files, row.Type)
+	}
+}
+
+// typeBreakdownJSON is the JSON form of one typeBreakdown.
+type typeBreakdownJSON struct {
+	Path         string    `json:"path"`
+	Bytes        int64     `json:"bytes"`
+	Files        int64     `json:"files"`
+	Extensions   []typeRow `json:"extensions"`
+	ContentTypes []typeRow `json:"content_types,omitempty"`
+}
+
+// runTypes implements `dirsize types [flags] [DIR]`.
+func runTypes(args []string) int {
+	flags := flag.NewFlagSet("types", flag.ExitOnError)
+	sniff := flags.Bool("content", false, "also detect content types from the first bytes of every file")
+	byDir := flags.Bool("by-dir", false, "also break down every top-level directory")
+	top := flags.Int("top", 15, "rows per table (0 = all)")
+	workers := flags.Int("workers", runtime.NumCPU(), "files read in parallel with -content")
+	noatime := flags.Bool("noatime", false, "don't update access times of the files and directories read (where permitted)")
+	asJSON := flags.Bool("json", false, "print the breakdown as JSON")
+	flags.Parse(args)
+
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+	report, err := breakdownByType(root, walkOptions{NoATime: *noatime}, *sniff, max(*workers, 1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Top-level directories, largest first; files directly in the root are
+	// listed among them as ".".
+	order := make([]int, 0, len(report.Groups))
+	for i, g := range report.Groups {
+		if g.Files > 0 {
+			order = append(order, i)
+		}
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return report.Groups[order[a]].Bytes > report.Groups[order[b]].Bytes
+	})
+
+	if *asJSON {
+		toJSON := func(path string, b *typeBreakdown) typeBreakdownJSON {
+			out := typeBreakdownJSON{Path: path, Bytes: b.Bytes, Files: b.Files, Extensions: sortedTypes(b.Extensions, *top)}
+			if *sniff {
+				out.ContentTypes = sortedTypes(b.ContentTypes, *top)
+			}
+			return out
+		}
+		var out struct {
+			Root        typeBreakdownJSON   `json:"root"`
+			Directories []typeBreakdownJSON `json:"directories,omitempty"`
+		}
+		out.Root = toJSON(root, report.Root)
+		if *byDir {
+			for _, i := range order {
+				out.Directories = append(out.Directories, toJSON(report.Names[i], report.Groups[i]))
+			}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	printTypes := func(b *typeBreakdown, indent string) {
+		printTypeTable("By extension", sortedTypes(b.Extensions, *top), b.Bytes, indent)
+		if *sniff {
+			printTypeTable("By content type", sortedTypes(b.ContentTypes, *top), b.Bytes, indent)
+		}
+	}
+	fmt.Printf("%s: %s in %d files\n", root, humanReadableBytes(report.Root.Bytes), report.Root.Files)
+	printTypes(report.Root, "")
+	if *byDir {
+		for _, i := range order {
+			g := report.Groups[i]
+			fmt.Printf("\n%s: %s in %d files\n", report.Names[i], humanReadableBytes(g.Bytes), g.Files)
+			printTypes(g, "  ")
+		}
+	}
+	return 0
+}
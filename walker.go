@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// walkEntry describes one file system object found by walkTree.
+//
+// The full path is not stored: building a path string for every one of tens
+// of millions of entries dominated the allocations of a scan. Dir is shared by
+// all entries of a directory, and Name may point into a reader's reusable
+// buffer, so it is only valid during the call to visit. Use strings.Clone (or
+// Path) to keep it.
+type walkEntry struct {
+	Dir   string      // path of the containing directory, "" for the root
+	Name  string      // base name; the full path as given for the root
+	Depth int         // 0 for the root, 1 for its children and so on
+	Mode  fs.FileMode // always holds the type bits, permissions only if Stat
+	// The fields below are only valid when Stat is true. Directories are not
+	// stat'ed unless walkOptions.StatDirs is set.
+	Stat   bool
+	Size   int64
+	Blocks int64 // 512-byte blocks actually allocated
+	Dev    uint64
+	Ino    uint64
+	Nlink  uint64
+	// Uid and Gid are only filled in by the fast Linux reader when
+	// walkOptions.StatOwners is set, and are always zero outside Unix.
+	Uid, Gid uint32
+	// ModTime and ATime are only filled in by the fast Linux reader when
+	// walkOptions.StatTimes is set; the portable reader always has them.
+	ModTime time.Time
+	ATime   time.Time
+	// Ref may be set by visit on a directory entry. It is handed back as
+	// ParentRef on every entry inside that directory, so callers can link
+	// entries into their own structures without looking up paths.
+	Ref       int
+	ParentRef int
+}
+
+// Path materializes the full path of the entry.
+func (e *walkEntry) Path() string {
+	if e.Dir == "" {
+		return e.Name
+	}
+	return filepath.Join(e.Dir, e.Name)
+}
+
+// walkOptions controls how walkTree reads the tree.
+type walkOptions struct {
+	// Workers is the number of directories read in parallel. Zero or less
+	// means one per CPU.
+	Workers int
+	// StatDirs asks for size and inode information on directories as well.
+	// Without it the walker only needs the entry type for directories, which
+	// the fast Linux reader gets for free from getdents64.
+	StatDirs bool
+	// StatTimes asks for modification and access times, which are left out
+	// of the minimal statx request otherwise.
+	StatTimes bool
+	// StatOwners asks for the owning user and group in the same way.
+	StatOwners bool
+	// Generic forces the portable os.ReadDir/Lstat reader even where a
+	// faster platform-specific one exists.
+	Generic bool
+	// Adaptive lets the walker tune the number of directories read in
+	// parallel to the latency of the storage, between MinWorkers and
+	// MaxWorkers, starting from Workers. See concurrencyController.
+	Adaptive   bool
+	MinWorkers int
+	MaxWorkers int
+	// OnConcurrency, if set, is told about every adaptive adjustment.
+	OnConcurrency func(workers int, latency time.Duration, opsPerSec float64)
+	// OpsPerSecond and DirsPerSecond cap the rate of metadata operations
+	// (stat calls) and of directories read, so a scan does not hurt the
+	// latency of production workloads. Zero means unlimited.
+	OpsPerSecond  int
+	DirsPerSecond int
+	// NoATime opens directories with O_NOATIME where permitted, so the scan
+	// leaves access times untouched.
+	NoATime bool
+	// Timeout, if set, is the longest a single readdir or stat may take.
+	// Directories that exceed it are abandoned, reported to OnTimeout and
+	// counted only as far as they were read; the rest of the scan goes on.
+	Timeout   time.Duration
+	OnTimeout func(dir string)
+	// OnProgress, if set, receives a snapshot of the walk every
+	// ProgressInterval (250ms by default) from a separate goroutine, and a
+	// final one with Done set once the walk is over.
+	OnProgress       func(scanProgress)
+	ProgressInterval time.Duration
+	// OnError is called for entries that could not be read. By default the
+	// error is printed and the walk continues, like calculateDirSize does.
+	OnError func(path string, err error)
+	// OnEntry, if set, sees every entry right after the scan's own visit
+	// function, so reports such as per-owner usage can ride along on any
+	// kind of scan instead of walking the tree again.
+	OnEntry func(*walkEntry)
+	// OnBatch, if set, gets the entries of each directory after they have
+	// all been visited. Unlike visit it runs outside the lock serializing
+	// visits, concurrently from several readers, so it may block (on a
+	// full channel, say) without stalling the rest of the walk. The names
+	// are only valid until it returns.
+	OnBatch func(entries []walkEntry)
+	// SkipDir, if set, is asked about every directory below the root before
+	// it is read. Directories it returns true for are still visited, but
+	// their contents are not, as for mount points with -x in du.
+	SkipDir func(path string) bool
+}
+
+// dirReader reads the entries of one directory. Each walker goroutine owns
+// its own reader, so implementations can keep reusable buffers without
+// locking.
+type dirReader interface {
+	// readDir calls emit for every entry of dir (excluding "." and "..").
+	// Names passed to emit must stay valid until the next call to readDir.
+	// depth is the depth of the entries themselves.
+	readDir(dir string, depth int, emit func(walkEntry), onError func(string, error))
+}
+
+// newDirReader returns the fastest reader available on this platform, or the
+// portable one when generic is set.
+func newDirReader(opts walkOptions) dirReader {
+	if opts.Generic {
+		return genericDirReader{statDirs: opts.StatDirs, noatime: opts.NoATime}
+	}
+	return newFastDirReader(opts)
+}
+
+// genericDirReader is the portable fallback built on os.ReadDir and Lstat.
+type genericDirReader struct {
+	statDirs bool
+	noatime  bool
+}
+
+func (r genericDirReader) readDir(dir string, depth int, emit func(walkEntry), onError func(string, error)) {
+	var entries []fs.DirEntry
+	var err error
+	if r.noatime {
+		var f *os.File
+		if f, err = openNoATime(dir); err == nil {
+			entries, err = f.ReadDir(-1)
+			f.Close()
+		}
+	} else {
+		entries, err = os.ReadDir(dir)
+	}
+	if err != nil {
+		onError(dir, err)
+	}
+	for _, de := range entries {
+		if de.IsDir() && !r.statDirs {
+			emit(walkEntry{Dir: dir, Name: de.Name(), Depth: depth, Mode: fs.ModeDir})
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			onError(filepath.Join(dir, de.Name()), err)
+			continue
+		}
+		emit(entryFromInfo(dir, de.Name(), depth, info))
+	}
+}
+
+// entryFromInfo converts the result of an Lstat into a walkEntry.
+func entryFromInfo(dir, name string, depth int, info fs.FileInfo) walkEntry {
+	e := walkEntry{Dir: dir, Name: name, Depth: depth, Mode: info.Mode(), Stat: true, Size: info.Size(), ModTime: info.ModTime(), ATime: accessTime(info)}
+	fillSysInfo(&e, info)
+	return e
+}
+
+// walkQueue is the shared frontier of directories waiting to be read. It
+// cannot be a plain buffered channel like the job queue in the worker pool
+// examples: workers discover new directories while they work, so a full
+// channel would deadlock them against each other.
+type walkQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []walkEntry
+	active  int // directories queued or currently being read
+}
+
+func (q *walkQueue) push(dir walkEntry) {
+	q.mu.Lock()
+	q.pending = append(q.pending, dir)
+	q.active++
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// pop blocks until a directory is available, or returns false once every
+// directory has been read.
+func (q *walkQueue) pop() (walkEntry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) == 0 && q.active > 0 {
+		q.cond.Wait()
+	}
+	if q.active == 0 {
+		return walkEntry{}, false
+	}
+	dir := q.pending[len(q.pending)-1]
+	q.pending = q.pending[:len(q.pending)-1]
+	return dir, true
+}
+
+// done marks a directory returned by pop as fully read.
+func (q *walkQueue) done() {
+	q.mu.Lock()
+	q.active--
+	finished := q.active == 0
+	q.mu.Unlock()
+	if finished {
+		q.cond.Broadcast()
+	}
+}
+
+// walkTree walks the tree rooted at root with a pool of workers and calls
+// visit for every entry, the root included. Calls to visit are serialized,
+// so it does not need its own locking. A directory is always visited before
+// anything inside it, but the order is otherwise not defined. Like
+// filepath.Walk, symbolic links are reported but not followed.
+func walkTree(root string, opts walkOptions, visit func(*walkEntry)) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fmt.Errorf("failed to walk directory '%s': %w", root, err)
+	}
+	rootEntry := entryFromInfo("", root, 0, info)
+
+	// Progress is counted by wrapping visit, which keeps the bookkeeping off
+	// the readers and costs a couple of atomic adds per entry.
+	var progress *progressCounters
+	if opts.OnProgress != nil {
+		progress = &progressCounters{start: time.Now()}
+		interval := opts.ProgressInterval
+		if interval <= 0 {
+			interval = defaultProgressInterval
+		}
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+			progress.report(opts.OnProgress, interval, stop)
+		}()
+		defer func() {
+			// A tick in flight must not draw after the final snapshot.
+			close(stop)
+			<-stopped
+			opts.OnProgress(progress.snapshot(true))
+		}()
+		inner := visit
+		visit = func(e *walkEntry) {
+			progress.count(e)
+			inner(e)
+		}
+	}
+
+	if opts.OnEntry != nil {
+		inner := visit
+		visit = func(e *walkEntry) {
+			inner(e)
+			opts.OnEntry(e)
+		}
+	}
+
+	visit(&rootEntry)
+	if !info.IsDir() {
+		return nil
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	onError := opts.OnError
+	if onError == nil {
+		onError = func(path string, err error) {
+			fmt.Printf("Error accessing %s: %v\n", path, err)
+		}
+	}
+	if progress != nil {
+		inner := onError
+		onError = func(path string, err error) {
+			progress.errors.Add(1)
+			inner(path, err)
+		}
+	}
+
+	var opsThrottle, dirsThrottle *throttle
+	if opts.OpsPerSecond > 0 {
+		opsThrottle = newThrottle(opts.OpsPerSecond)
+		defer opsThrottle.close()
+	}
+	if opts.DirsPerSecond > 0 {
+		dirsThrottle = newThrottle(opts.DirsPerSecond)
+		defer dirsThrottle.close()
+	}
+
+	// In adaptive mode MaxWorkers goroutines are started and the controller
+	// decides how many of them may read a directory at any one time.
+	var ctl *concurrencyController
+	if opts.Adaptive {
+		ctl = newConcurrencyController(workers, opts)
+		workers = ctl.max
+		stop := make(chan struct{})
+		defer close(stop)
+		go ctl.run(stop)
+	}
+
+	var visitMu sync.Mutex
+	queue := &walkQueue{}
+	queue.cond = sync.NewCond(&queue.mu)
+	queue.push(rootEntry)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reader := newDirReader(opts)
+			// Entries are collected per directory and handed to visit in
+			// one batch to keep contention on visitMu low. Readers stat an
+			// entry right before emitting it, so waiting here paces the
+			// stat calls themselves.
+			var batch []walkEntry
+			emit := func(e walkEntry) {
+				if e.Stat {
+					opsThrottle.wait()
+				}
+				batch = append(batch, e)
+			}
+			for {
+				dir, ok := queue.pop()
+				if !ok {
+					return
+				}
+				// The path of a directory is built once and shared as Dir
+				// by all of its entries.
+				dirPath := dir.Path()
+				if progress != nil {
+					progress.current.Store(&dirPath)
+				}
+				batch = batch[:0]
+				dirsThrottle.wait()
+				opsThrottle.wait()
+				var start time.Time
+				if ctl != nil {
+					ctl.acquire()
+					start = time.Now()
+				}
+				timedOut := false
+				if opts.Timeout > 0 {
+					batch, timedOut = readDirTimeout(reader, dirPath, dir.Depth+1, opsThrottle, onError, opts.Timeout)
+					if timedOut {
+						// The old reader is stuck in the kernel; carry on
+						// with a fresh one.
+						reader = newDirReader(opts)
+					}
+				} else {
+					reader.readDir(dirPath, dir.Depth+1, emit, onError)
+				}
+				if ctl != nil {
+					ctl.release(len(batch)+1, time.Since(start))
+				}
+				visitMu.Lock()
+				if timedOut && opts.OnTimeout != nil {
+					opts.OnTimeout(dirPath)
+				}
+				for i := range batch {
+					batch[i].ParentRef = dir.Ref
+					visit(&batch[i])
+				}
+				visitMu.Unlock()
+				if opts.OnBatch != nil {
+					opts.OnBatch(batch)
+				}
+				for _, e := range batch {
+					if e.Mode.IsDir() && (opts.SkipDir == nil || !opts.SkipDir(filepath.Join(dirPath, e.Name))) {
+						// Queued directories outlive the reader's buffer.
+						e.Name = strings.Clone(e.Name)
+						queue.push(e)
+					}
+				}
+				queue.done()
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// scanDirSize is the parallel counterpart of calculateDirSize. It returns the
+// same total, the sum of the sizes of everything that is not a directory.
+func scanDirSize(dirPath string, opts walkOptions) (int64, error) {
+	var totalSize int64
+	err := walkTree(dirPath, opts, func(e *walkEntry) {
+		if !e.Mode.IsDir() {
+			totalSize += e.Size
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return totalSize, nil
+}
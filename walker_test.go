@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// makeSyntheticTree fills dir with a balanced tree of small files: fanout
+// subdirectories per level, depth levels deep, with filesPerDir files in
+// every directory. It returns the number of files created.
+func makeSyntheticTree(tb testing.TB, dir string, fanout, depth, filesPerDir int) int {
+	tb.Helper()
+	created := 0
+	payload := make([]byte, 100)
+	var build func(dir string, level int)
+	build = func(dir string, level int) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		for i := 0; i < filesPerDir; i++ {
+			name := filepath.Join(dir, fmt.Sprintf("file%04d.dat", i))
+			if err := os.WriteFile(name, payload[:i%len(payload)], 0644); err != nil {
+				tb.Fatal(err)
+			}
+			created++
+		}
+		if level == depth {
+			return
+		}
+		for i := 0; i < fanout; i++ {
+			build(filepath.Join(dir, fmt.Sprintf("dir%03d", i)), level+1)
+		}
+	}
+	build(dir, 0)
+	return created
+}
+
+// benchTree is shared by the benchmarks so the tree is only built once.
+var benchTree struct {
+	dir   string
+	files int
+}
+
+func syntheticBenchTree(b *testing.B) (string, int) {
+	b.Helper()
+	if benchTree.dir == "" {
+		dir, err := os.MkdirTemp("", "dirsize-bench-")
+		if err != nil {
+			b.Fatal(err)
+		}
+		benchTree.dir = dir
+		benchTree.files = makeSyntheticTree(b, dir, 10, 3, 100)
+	}
+	return benchTree.dir, benchTree.files
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if benchTree.dir != "" {
+		os.RemoveAll(benchTree.dir)
+	}
+	os.Exit(code)
+}
+
+// BenchmarkWalk compares the walkers on a synthetic tree of about 110,000
+// small files.
+func BenchmarkWalk(b *testing.B) {
+	dir, files := syntheticBenchTree(b)
+	walkers := []struct {
+		name string
+		scan func() (int64, error)
+	}{
+		{"filepath.Walk", func() (int64, error) { return calculateDirSize(dir) }},
+		{"generic", func() (int64, error) { return scanDirSize(dir, walkOptions{Generic: true}) }},
+		{"fast", func() (int64, error) { return scanDirSize(dir, walkOptions{}) }},
+	}
+	for _, w := range walkers {
+		b.Run(w.name, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := w.scan(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(files)*float64(b.N)/b.Elapsed().Seconds(), "files/s")
+		})
+	}
+}
+
+// BenchmarkSizeTree reports how much heap a sizeTree built from disk
+// retains and how many allocations it takes, per entry.
+func BenchmarkSizeTree(b *testing.B) {
+	dir, _ := syntheticBenchTree(b)
+	var retained, allocs float64
+	for b.Loop() {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		tree, err := buildSizeTree(dir, walkOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		entries := float64(len(tree.nodes))
+		retained = float64(after.HeapAlloc-before.HeapAlloc) / entries
+		allocs = float64(after.Mallocs-before.Mallocs) / entries
+		runtime.KeepAlive(tree)
+	}
+	b.ReportMetric(retained, "bytes/entry")
+	b.ReportMetric(allocs, "allocs/entry")
+}
+
+// TestSizeTreeTenMillionEntries checks the memory target of the name arena:
+// ten million entries with typical names must fit in under 1 GiB of heap.
+func TestSizeTreeTenMillionEntries(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a ten million entry tree")
+	}
+	const entries = 10_000_000
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	tree := &sizeTree{}
+	if _, err := tree.add(0, "/data", 0, fs.ModeDir); err != nil {
+		t.Fatal(err)
+	}
+	// Directories of 100 files each, ten directories per parent.
+	var dirs []uint32
+	for i := 1; len(tree.nodes) < entries; i++ {
+		parent := uint32(0)
+		if len(dirs) >= 10 {
+			parent = dirs[len(dirs)/10-1]
+		}
+		dir, err := tree.add(parent, fmt.Sprintf("directory%06d", i), 0, fs.ModeDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+		for f := 0; f < 100 && len(tree.nodes) < entries; f++ {
+			if _, err := tree.add(dir, fmt.Sprintf("file%04d.dat", f), int64(f), 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tree.finish()
+	dirs = nil
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	used := int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	t.Logf("%d entries: %s, %.1f bytes/entry", len(tree.nodes), humanReadableBytes(used), float64(used)/entries)
+	if used >= 1<<30 {
+		t.Errorf("ten million entries took %s, want under 1 GiB", humanReadableBytes(used))
+	}
+	runtime.KeepAlive(tree)
+}
+
+// TestWalkersAgreeOnDevices checks that the fast and the portable readers
+// encode device numbers the same way, since hard-link and mount checks
+// compare them.
+func TestWalkersAgreeOnDevices(t *testing.T) {
+	dir := t.TempDir()
+	makeSyntheticTree(t, dir, 2, 1, 3)
+	devices := func(generic bool) map[string]uint64 {
+		devs := make(map[string]uint64)
+		opts := walkOptions{Generic: generic, OnEntry: func(e *walkEntry) {
+			if e.Stat {
+				devs[filepath.Join(e.Dir, e.Name)] = e.Dev
+			}
+		}}
+		if _, err := scanDirSize(dir, opts); err != nil {
+			t.Fatal(err)
+		}
+		return devs
+	}
+	fast, generic := devices(false), devices(true)
+	if len(fast) == 0 || len(fast) != len(generic) {
+		t.Fatalf("fast reader saw %d files, portable reader %d", len(fast), len(generic))
+	}
+	for path, dev := range generic {
+		if fast[path] != dev {
+			t.Errorf("%s: fast reader reports device %#x, portable reader %#x", path, fast[path], dev)
+		}
+	}
+}
+
+// TestProgressDoneIsLast checks that no progress tick is delivered after the
+// final snapshot, even when a tick is still being drawn as the walk ends.
+func TestProgressDoneIsLast(t *testing.T) {
+	dir := t.TempDir()
+	makeSyntheticTree(t, dir, 3, 2, 20)
+	for i := 0; i < 20; i++ {
+		var mu sync.Mutex
+		var calls []bool
+		opts := walkOptions{ProgressInterval: time.Microsecond, OnProgress: func(p scanProgress) {
+			if !p.Done {
+				time.Sleep(time.Millisecond)
+			}
+			mu.Lock()
+			calls = append(calls, p.Done)
+			mu.Unlock()
+		}}
+		if _, err := scanDirSize(dir, opts); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+		mu.Lock()
+		if len(calls) == 0 || !calls[len(calls)-1] {
+			t.Fatalf("run %d: progress after the final snapshot: %v", i, calls)
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchMask is the set of inotify events that can change the size of a
+// directory tree. IN_ONLYDIR makes the kernel refuse watches on anything that
+// is not a directory, which protects us from races with renames.
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_ONLYDIR
+
+// dirWatcher keeps per-directory totals for a tree up to date from inotify
+// events. Totals are recursive: every directory holds the sum of all file
+// sizes beneath it, just like calculateDirSize would report for it.
+type dirWatcher struct {
+	mu   sync.Mutex
+	root string
+	fd   int
+	// files holds the last known size of every file, indexed by directory
+	// and then by name, and subdirs the known subdirectories of each
+	// directory, so a subtree can be dropped without looking at the rest.
+	files   map[string]map[string]int64
+	subdirs map[string]map[string]bool
+	nfiles  int
+	// totals holds the recursive size of every known directory.
+	totals map[string]int64
+	// newest holds, per directory, the latest change seen beneath it in
+	// Unix nanoseconds: the newest file modification time, or the time a
+	// file or directory was removed from it.
+	newest map[string]int64
+	wds    map[int32]string // watch descriptor -> directory path
+	paths  map[string]int32 // directory path -> watch descriptor
+	// unwatched holds the topmost directories we could not watch because the
+	// inotify watch limit (fs.inotify.max_user_watches) was exhausted. Their
+	// subtrees are kept up to date by periodic rescans instead.
+	unwatched map[string]bool
+}
+
+func newDirWatcher(root string) (*dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise inotify: %w", err)
+	}
+	return &dirWatcher{
+		root:      filepath.Clean(root),
+		fd:        fd,
+		files:     make(map[string]map[string]int64),
+		subdirs:   make(map[string]map[string]bool),
+		totals:    make(map[string]int64),
+		newest:    make(map[string]int64),
+		wds:       make(map[int32]string),
+		paths:     make(map[string]int32),
+		unwatched: make(map[string]bool),
+	}, nil
+}
+
+// adjust adds delta to dir and every one of its ancestors up to the root.
+func (w *dirWatcher) adjust(dir string, delta int64) {
+	if delta == 0 {
+		return
+	}
+	for d := dir; ; {
+		w.totals[d] += delta
+		parent := filepath.Dir(d)
+		if d == w.root || parent == d {
+			return
+		}
+		d = parent
+	}
+}
+
+// touch records a change at time t (Unix nanoseconds) in dir. Every
+// directory's newest change is at least as recent as any of its
+// subdirectories', so the climb stops at the first ancestor that already
+// has a later one.
+func (w *dirWatcher) touch(dir string, t int64) {
+	for d := dir; w.newest[d] < t; {
+		w.newest[d] = t
+		parent := filepath.Dir(d)
+		if d == w.root || parent == d {
+			return
+		}
+		d = parent
+	}
+}
+
+// setFile records the current size and modification time of a file. It is
+// idempotent, so seeing the same file from both an event and a scan never
+// counts it twice.
+func (w *dirWatcher) setFile(path string, size int64, modTime time.Time) {
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	files := w.files[dir]
+	if files == nil {
+		files = make(map[string]int64)
+		w.files[dir] = files
+	}
+	old, ok := files[name]
+	if !ok {
+		w.nfiles++
+	}
+	files[name] = size
+	w.adjust(dir, size-old)
+	w.touch(dir, modTime.UnixNano())
+}
+
+func (w *dirWatcher) removeFile(path string) {
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	if size, ok := w.files[dir][name]; ok {
+		delete(w.files[dir], name)
+		w.nfiles--
+		w.adjust(dir, -size)
+		w.touch(dir, time.Now().UnixNano())
+	}
+}
+
+// addDir records dir as known, with a zero total if it is new.
+func (w *dirWatcher) addDir(dir string) {
+	if _, ok := w.totals[dir]; ok {
+		return
+	}
+	w.totals[dir] = 0
+	if dir == w.root {
+		return
+	}
+	parent, name := filepath.Split(dir)
+	parent = filepath.Clean(parent)
+	if w.subdirs[parent] == nil {
+		w.subdirs[parent] = make(map[string]bool)
+	}
+	w.subdirs[parent][name] = true
+}
+
+// statFile refreshes a single entry after an event told us it changed.
+func (w *dirWatcher) statFile(path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		// The file is already gone again; its delete event will follow.
+		w.removeFile(path)
+		return
+	}
+	if info.IsDir() {
+		w.addTree(path)
+		return
+	}
+	w.setFile(path, info.Size(), info.ModTime())
+}
+
+// covered reports whether dir lies inside a subtree that is maintained by
+// periodic rescans rather than by inotify.
+func (w *dirWatcher) covered(dir string) bool {
+	for d := dir; ; {
+		if w.unwatched[d] {
+			return true
+		}
+		parent := filepath.Dir(d)
+		if d == w.root || parent == d {
+			return false
+		}
+		d = parent
+	}
+}
+
+// watchDir adds an inotify watch for dir. When the kernel runs out of watches
+// the directory is handed over to the periodic rescan instead.
+func (w *dirWatcher) watchDir(dir string) {
+	if _, ok := w.paths[dir]; ok || w.covered(dir) {
+		return
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, watchMask)
+	if errors.Is(err, syscall.ENOSPC) {
+		w.unwatched[dir] = true
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error watching %s: %v\n", dir, err)
+		return
+	}
+	w.wds[int32(wd)] = dir
+	w.paths[dir] = int32(wd)
+}
+
+// addTree scans dir, records every file in it and watches every directory.
+// Watches are added before a directory is read so nothing created in between
+// is missed.
+func (w *dirWatcher) addTree(dir string) {
+	filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error accessing %s: %v\n", path, err)
+			return nil
+		}
+		if info.IsDir() {
+			w.addDir(path)
+			w.watchDir(path)
+			return nil
+		}
+		w.setFile(path, info.Size(), info.ModTime())
+		return nil
+	})
+}
+
+// removeTree forgets dir and everything beneath it, subtracting its total
+// from the ancestors' totals and dropping any watches inside it. Only the
+// subtree itself is visited.
+func (w *dirWatcher) removeTree(dir string) {
+	total, ok := w.totals[dir]
+	if !ok {
+		return
+	}
+	if dir == w.root {
+		w.totals[dir] = 0
+	} else {
+		parent, name := filepath.Split(dir)
+		parent = filepath.Clean(parent)
+		w.adjust(parent, -total)
+		w.touch(parent, time.Now().UnixNano())
+		delete(w.subdirs[parent], name)
+	}
+	w.forget(dir)
+}
+
+// forget drops the records of dir and its subtree without touching any
+// totals outside it.
+func (w *dirWatcher) forget(dir string) {
+	for name := range w.subdirs[dir] {
+		w.forget(filepath.Join(dir, name))
+	}
+	delete(w.subdirs, dir)
+	w.nfiles -= len(w.files[dir])
+	delete(w.files, dir)
+	if dir != w.root {
+		delete(w.totals, dir)
+		delete(w.newest, dir)
+	}
+	if wd, ok := w.paths[dir]; ok {
+		syscall.InotifyRmWatch(w.fd, uint32(wd))
+		delete(w.paths, dir)
+		delete(w.wds, wd)
+	}
+	delete(w.unwatched, dir)
+}
+
+// rescan rebuilds a subtree from scratch. Removing and re-adding also retries
+// any watches that previously failed, so subtrees move back to inotify once
+// watches become available again.
+func (w *dirWatcher) rescan(dir string) {
+	w.removeTree(dir)
+	w.addTree(dir)
+}
+
+// rescanUnwatched refreshes every subtree that has no inotify coverage.
+func (w *dirWatcher) rescanUnwatched() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dirs := make([]string, 0, len(w.unwatched))
+	for dir := range w.unwatched {
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		w.rescan(dir)
+	}
+}
+
+// handleEvent applies a single inotify event to the totals.
+func (w *dirWatcher) handleEvent(wd int32, mask uint32, name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		// We lost events, so nothing we hold can be trusted any more.
+		w.rescan(w.root)
+		return
+	}
+	dir, ok := w.wds[wd]
+	if !ok {
+		return
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		// The kernel removed the watch (the directory was deleted or its
+		// file system unmounted).
+		delete(w.wds, wd)
+		if w.paths[dir] == wd {
+			delete(w.paths, dir)
+		}
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	isDir := mask&syscall.IN_ISDIR != 0
+	switch {
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		if isDir {
+			w.removeTree(path)
+		} else {
+			w.removeFile(path)
+		}
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		if isDir {
+			w.addTree(path)
+		} else {
+			w.statFile(path)
+		}
+	case mask&(syscall.IN_MODIFY|syscall.IN_CLOSE_WRITE) != 0:
+		w.statFile(path)
+	}
+}
+
+// readEvents decodes raw inotify events from the watcher's file descriptor
+// until it is closed.
+func (w *dirWatcher) readEvents() error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read inotify events: %w", err)
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[start:start+int(ev.Len)]), "\x00")
+			w.handleEvent(ev.Wd, ev.Mask, name)
+			off = start + int(ev.Len)
+		}
+	}
+}
+
+// formatRate renders a growth rate in bytes per second, keeping the sign so
+// shrinking directories stand out.
+func formatRate(bytesPerSec float64) string {
+	sign := "+"
+	if bytesPerSec < 0 {
+		sign = "-"
+		bytesPerSec = -bytesPerSec
+	}
+	return sign + humanReadableBytes(int64(bytesPerSec)) + "/s"
+}
+
+// render draws the refreshing top-N view, largest or, with sortBy "newest",
+// most recently changed first. prev holds the totals from the previous
+// refresh and is updated in place to compute growth rates.
+func (w *dirWatcher) render(top int, sortBy string, prev map[string]int64, elapsed time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dirs := make([]string, 0, len(w.totals))
+	for dir := range w.totals {
+		if dir != w.root {
+			dirs = append(dirs, dir)
+		}
+	}
+	if sortBy == "newest" {
+		sort.Slice(dirs, func(i, j int) bool { return w.newest[dirs[i]] > w.newest[dirs[j]] })
+	} else {
+		sort.Slice(dirs, func(i, j int) bool { return w.totals[dirs[i]] > w.totals[dirs[j]] })
+	}
+	if len(dirs) > top {
+		dirs = dirs[:top]
+	}
+
+	rate := func(dir string) string {
+		old, ok := prev[dir]
+		if !ok || elapsed <= 0 {
+			return "-"
+		}
+		return formatRate(float64(w.totals[dir]-old) / elapsed.Seconds())
+	}
+
+	// Clear the screen and move the cursor home before redrawing.
+	fmt.Print("\033[H\033[2J")
+	fmt.Printf("Watching %s: %s in %d files (%s)\n", w.root, humanReadableBytes(w.totals[w.root]), w.nfiles, rate(w.root))
+	fmt.Printf("%d directories watched, %d subtrees on periodic rescan\n\n", len(w.paths), len(w.unwatched))
+	changed := func(dir string) string {
+		if w.newest[dir] == 0 {
+			return "-"
+		}
+		return time.Unix(0, w.newest[dir]).Format("2006-01-02 15:04")
+	}
+	fmt.Printf("%12s  %14s  %16s  %s\n", "SIZE", "GROWTH", "CHANGED", "DIRECTORY")
+	for _, dir := range dirs {
+		fmt.Printf("%12s  %14s  %16s  %s\n", humanReadableBytes(w.totals[dir]), rate(dir), changed(dir), dir)
+	}
+
+	clear(prev)
+	for dir, total := range w.totals {
+		prev[dir] = total
+	}
+}
+
+// runWatch implements `dirsize watch [flags] DIR`.
+func runWatch(args []string) int {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	top := flags.Int("top", 20, "number of directories to display")
+	interval := flags.Duration("interval", 2*time.Second, "display refresh interval")
+	rescanEvery := flags.Duration("rescan", 30*time.Second, "rescan interval for subtrees that could not be watched")
+	sortBy := flags.String("sort", "size", "order directories by size or newest (most recently changed first)")
+	flags.Parse(args)
+
+	if *sortBy != "size" && *sortBy != "newest" {
+		fmt.Fprintf(os.Stderr, "invalid sort order %q: want size or newest\n", *sortBy)
+		return 2
+	}
+	if *top < 0 {
+		fmt.Fprintln(os.Stderr, "dirsize watch: -top must not be negative")
+		return 2
+	}
+	if *interval <= 0 || *rescanEvery <= 0 {
+		fmt.Fprintln(os.Stderr, "dirsize watch: -interval and -rescan must be positive")
+		return 2
+	}
+
+	root := "."
+	if flags.NArg() > 0 {
+		root = flags.Arg(0)
+	}
+
+	w, err := newDirWatcher(root)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer syscall.Close(w.fd)
+
+	w.mu.Lock()
+	w.addTree(w.root)
+	w.mu.Unlock()
+	if len(w.unwatched) > 0 {
+		fmt.Fprintf(os.Stderr, "inotify watch limit reached, %d subtrees will be rescanned every %v\n", len(w.unwatched), *rescanEvery)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- w.readEvents() }()
+
+	refresh := time.NewTicker(*interval)
+	defer refresh.Stop()
+	rescan := time.NewTicker(*rescanEvery)
+	defer rescan.Stop()
+
+	prev := make(map[string]int64)
+	last := time.Now()
+	w.render(*top, *sortBy, prev, 0)
+	for {
+		select {
+		case err := <-errc:
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		case <-rescan.C:
+			w.rescanUnwatched()
+		case now := <-refresh.C:
+			w.render(*top, *sortBy, prev, now.Sub(last))
+			last = now
+		}
+	}
+}
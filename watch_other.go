@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runWatch is only implemented on Linux, where inotify is available.
+func runWatch(args []string) int {
+	fmt.Fprintln(os.Stderr, "dirsize watch: live watching requires Linux inotify")
+	return 1
+}